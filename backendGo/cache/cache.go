@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"container/list"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Cache keeps the most recently used values up to a total size. Sizes are
+// given by the caller, usually an estimate of the value's memory in bytes.
+type Cache struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key   string
+	value any
+	size  int64
+}
+
+// New creates a cache of at most maxSize, a cache of size 0 keeps nothing.
+func New(maxSize int64) *Cache {
+	return &Cache{
+		maxSize: maxSize,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// NewFromEnv reads the size in MB from the environment variable name,
+// defaultMB when it is not set.
+func NewFromEnv(name string, defaultMB int64) *Cache {
+	sizeMB := defaultMB
+	if value := os.Getenv(name); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			log.Printf("Invalid %s %q, using %d: %v", name, value, sizeMB, err)
+		} else {
+			sizeMB = parsed
+		}
+	}
+	return New(sizeMB << 20)
+}
+
+// Get returns the value stored under key and marks it as recently used.
+func (c *Cache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*entry).value, true
+}
+
+// Add stores value under key and evicts the least recently used values until
+// the cache fits its size. Values larger than the whole cache are not stored.
+func (c *Cache) Add(key string, value any, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	if size > c.maxSize {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&entry{key: key, value: value, size: size})
+	c.size += size
+	for c.size > c.maxSize {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *Cache) remove(element *list.Element) {
+	e := c.order.Remove(element).(*entry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+}
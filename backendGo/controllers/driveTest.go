@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	. "backendGo/types"
+	"backendGo/utils/calculations"
+	"backendGo/utils/raylaunching"
+	stdcontext "context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DriveTestRequest compares measurements either with the power map of a
+// finished ray launching job (JobID) or with a new simulation (Config).
+type DriveTestRequest struct {
+	Measurements []Measurement     `json:"measurements" binding:"required,min=1,max=100000,dive"`
+	JobID        string            `json:"jobId"`
+	Config       *RayLaunchRequest `json:"config"`
+}
+
+// driveTestResult is the result of a drive test job that ran its own
+// simulation, GetRayLaunchJobResult sends its response.
+type driveTestResult struct {
+	response gin.H
+}
+
+// driveTestPoints places the measurements in a power map with the given
+// grid. Heights are rounded to the nearest level.
+func driveTestPoints(measurements []Measurement, grid calculations.MapGrid) []raylaunching.DriveTestPoint {
+	points := make([]raylaunching.DriveTestPoint, len(measurements))
+	for i, measurement := range measurements {
+		x, y := grid.ProcessedIndex(*measurement.Lat, *measurement.Lon)
+		points[i] = raylaunching.DriveTestPoint{
+			Measurement: measurement,
+			X:           x,
+			Y:           y,
+			Z:           grid.HeightToLevel(measurement.Height),
+		}
+	}
+	return points
+}
+
+// CompareDriveTest answers right away for a JobID. For a Config it submits a
+// job that runs the simulation first, its result is fetched from
+// /rayLaunch/jobs/:jobId/result like the result of any other job.
+func CompareDriveTest(context *gin.Context) {
+	mapTitle := context.Param("mapTitle")
+
+	var request DriveTestRequest
+	if err := context.ShouldBindJSON(&request); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (request.JobID == "") == (request.Config == nil) {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Exactly one of jobId and config is required"})
+		return
+	}
+	if request.JobID != "" {
+		job, ok := rayLaunchJobs().Get(request.JobID)
+		if ok && job.MapTitle != mapTitle {
+			context.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Job %s belongs to map %s", job.ID, job.MapTitle)})
+			return
+		}
+		finished, ok := finishedRayLaunchJobResult(context, request.JobID)
+		if !ok {
+			return
+		}
+		response := finished.response
+		powerMap, ok := response["powerMap"].([][][]float64)
+		grid, hasGrid := response["grid"].(calculations.MapGrid)
+		if !ok || !hasGrid {
+			context.JSON(http.StatusInternalServerError, gin.H{"error": "Job result has no power map"})
+			return
+		}
+		points := driveTestPoints(request.Measurements, grid)
+		stats := raylaunching.CompareDriveTest(powerMap, calculations.WallMapNumber, points)
+		context.JSON(http.StatusOK, gin.H{
+			"mapTitle": mapTitle,
+			"jobId":    request.JobID,
+			"points":   points,
+			"stats":    stats,
+		})
+		return
+	}
+
+	run, ok := newRayLaunchRun(context, mapTitle, *request.Config)
+	if !ok {
+		return
+	}
+	points := driveTestPoints(request.Measurements, run.grid)
+	job := rayLaunchJobs().Submit(mapTitle, func(ctx stdcontext.Context, report func(float64)) (any, error) {
+		cached, isCached := run.cachedResult()
+		if !isCached {
+			result, err := run.calculate(ctx, report)
+			if err != nil {
+				return nil, err
+			}
+			run.cacheResult(result, 0)
+			cached = &cachedRayLaunch{result: result}
+		}
+		result := cached.result
+		stats := raylaunching.CompareDriveTest(result.PowerMap, run.config.WallMapNumber, points)
+		return &driveTestResult{response: gin.H{
+			"mapTitle":       mapTitle,
+			"grid":           run.grid,
+			"points":         points,
+			"stats":          stats,
+			"powerMap":       result.PowerMap,
+			"powerMapLegend": result.PowerMapLegend,
+			"cached":         isCached,
+		}}, nil
+	})
+	context.JSON(http.StatusAccepted, job)
+}
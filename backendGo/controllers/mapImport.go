@@ -0,0 +1,304 @@
+package controllers
+
+import (
+	"backendGo/db"
+	"backendGo/jobs"
+	. "backendGo/types"
+	"backendGo/utils/calculations"
+	stdcontext "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Defaults of imported maps: 1 m cubic voxels up to 30 m above the ground.
+const (
+	defaultImportCellSize    = 1.0
+	defaultImportLevelHeight = 1.0
+	defaultImportMaxHeight   = 30.0
+)
+
+// maxImportSide limits the sides of imported maps in metres. The wall matrix
+// labels at most RoofMapNumber-WallMapNumber walls, dense city centres reach
+// that on a few square kilometres already.
+const maxImportSide = 1000.0
+
+var (
+	mapImportJobManager     *jobs.Manager
+	mapImportJobManagerOnce sync.Once
+
+	// mapsFileMu guards data/maps.json.
+	mapsFileMu sync.Mutex
+
+	mapIdPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+)
+
+// mapImportJobs runs one import at a time, the preprocessing is memory heavy.
+func mapImportJobs() *jobs.Manager {
+	mapImportJobManagerOnce.Do(func() {
+		mapImportJobManager = jobs.NewManager(jobs.ResultTTLFromEnv(), 1)
+	})
+	return mapImportJobManager
+}
+
+type MapBounds struct {
+	LatMin float64 `json:"latMin" binding:"required,gte=-90,lte=90"`
+	LatMax float64 `json:"latMax" binding:"required,gte=-90,lte=90"`
+	LonMin float64 `json:"lonMin" binding:"required,gte=-180,lte=180"`
+	LonMax float64 `json:"lonMax" binding:"required,gte=-180,lte=180"`
+}
+
+type MapImportRequest struct {
+	ID          string    `json:"id" binding:"required"`
+	Name        string    `json:"name" binding:"required,max=100"`
+	Description string    `json:"description" binding:"max=1000"`
+	CellSize    float64   `json:"cellSize" binding:"omitempty,gte=0.5,lte=10"`    // metres, 1 by default
+	LevelHeight float64   `json:"levelHeight" binding:"omitempty,gte=0.5,lte=10"` // metres, 1 by default
+	MaxHeight   float64   `json:"maxHeight" binding:"omitempty,gt=0,lte=1000"`    // metres, 30 by default
+	FloorHeight float64   `json:"floorHeight" binding:"omitempty,gt=0,lte=10"`
+	Bounds      MapBounds `json:"bounds" binding:"required"`
+	// Buildings is a GeoJSON FeatureCollection, stored as rawBuildings.json.
+	Buildings json.RawMessage `json:"buildings" binding:"required"`
+}
+
+func (request *MapImportRequest) validate() error {
+	if !mapIdPattern.MatchString(request.ID) {
+		return fmt.Errorf("id must consist of lower case letters, digits and dashes")
+	}
+	if request.Bounds.LatMin >= request.Bounds.LatMax || request.Bounds.LonMin >= request.Bounds.LonMax {
+		return fmt.Errorf("bounds must satisfy latMin < latMax and lonMin < lonMax")
+	}
+	grid := calculations.NewMapGrid(request.mapConfig())
+	if err := grid.Validate(); err != nil {
+		return err
+	}
+	width := float64(grid.SizeX-1) * grid.MetresPerCellX
+	height := float64(grid.SizeY-1) * grid.MetresPerCellY
+	if width > maxImportSide || height > maxImportSide {
+		return fmt.Errorf("bounds span %.0fx%.0f m, at most %.0f m per side are supported", width, height, maxImportSide)
+	}
+	if request.mapConfig().HeightMaxLevels < 2 {
+		return fmt.Errorf("maxHeight must span at least two levels of levelHeight")
+	}
+	var buildings struct {
+		Type     string            `json:"type"`
+		Features []json.RawMessage `json:"features"`
+	}
+	if err := json.Unmarshal(request.Buildings, &buildings); err != nil {
+		return fmt.Errorf("buildings is not valid GeoJSON: %w", err)
+	}
+	if buildings.Type != "FeatureCollection" || len(buildings.Features) == 0 {
+		return fmt.Errorf("buildings must be a non empty GeoJSON FeatureCollection")
+	}
+	return nil
+}
+
+// mapConfig returns the preprocessing configuration of the imported map, a
+// metric grid of cellSize metres and maxHeight metres of levelHeight levels.
+func (request *MapImportRequest) mapConfig() MapConfig {
+	cellSize := request.CellSize
+	if cellSize == 0 {
+		cellSize = defaultImportCellSize
+	}
+	levelHeight := request.LevelHeight
+	if levelHeight == 0 {
+		levelHeight = defaultImportLevelHeight
+	}
+	maxHeight := request.MaxHeight
+	if maxHeight == 0 {
+		maxHeight = defaultImportMaxHeight
+	}
+	return MapConfig{
+		LatMin:          request.Bounds.LatMin,
+		LatMax:          request.Bounds.LatMax,
+		LonMin:          request.Bounds.LonMin,
+		LonMax:          request.Bounds.LonMax,
+		HeightMaxLevels: int(math.Ceil(maxHeight / levelHeight)),
+		FloorHeight:     request.FloorHeight,
+		CellSize:        cellSize,
+		LevelHeight:     levelHeight,
+	}
+}
+
+func readMaps(cwd string) ([]Map, error) {
+	data, err := os.ReadFile(filepath.Join(cwd, "data", "maps.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var maps []Map
+	err = json.Unmarshal(data, &maps)
+	return maps, err
+}
+
+func isMapRegistered(cwd, id string) (bool, error) {
+	mapsFileMu.Lock()
+	defer mapsFileMu.Unlock()
+	maps, err := readMaps(cwd)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range maps {
+		if m.ID == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// registerMap adds entry to data/maps.json so that it is listed by GetMaps.
+func registerMap(cwd string, entry Map) error {
+	mapsFileMu.Lock()
+	defer mapsFileMu.Unlock()
+	maps, err := readMaps(cwd)
+	if err != nil {
+		return err
+	}
+	for _, m := range maps {
+		if m.ID == entry.ID {
+			return fmt.Errorf("map %s is already registered", entry.ID)
+		}
+	}
+	data, err := json.MarshalIndent(append(maps, entry), "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(cwd, "data", "maps.json"), data, 0644)
+}
+
+func writeJSONFile(path string, value any) error {
+	data, err := json.MarshalIndent(value, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// importMap writes the input files of a new map, runs the preprocessing and
+// registers the map. A failed import leaves no trace in data.
+func importMap(ctx stdcontext.Context, cwd string, request MapImportRequest, report func(float64)) (any, error) {
+	mapPath := filepath.Join(cwd, "data", request.ID)
+	if err := os.Mkdir(mapPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create map directory: %w", err)
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			if removeErr := os.RemoveAll(mapPath); removeErr != nil {
+				log.Printf("Failed to clean up %s: %v", mapPath, removeErr)
+			}
+		}
+	}()
+
+	bounds := request.Bounds
+	mapConfig := request.mapConfig()
+	grid := calculations.NewMapGrid(mapConfig)
+	// size is the longer side of the map in metres, as listed by GetMaps
+	size := int(math.Round(float64(max(grid.SizeX, grid.SizeY)-1) * mapConfig.CellSize))
+	mapData := MapConfiguration{
+		Title: request.Name,
+		Coordinates: [][][]float64{{
+			{bounds.LonMin, bounds.LatMin},
+			{bounds.LonMax, bounds.LatMin},
+			{bounds.LonMax, bounds.LatMax},
+			{bounds.LonMin, bounds.LatMax},
+			{bounds.LonMin, bounds.LatMin},
+		}},
+		Center: [2]float64{(bounds.LonMin + bounds.LonMax) / 2, (bounds.LatMin + bounds.LatMax) / 2},
+		Bounds: [2][2]float64{{bounds.LonMin, bounds.LatMin}, {bounds.LonMax, bounds.LatMax}},
+		Size:   size,
+		Grid:   &grid,
+	}
+	if err := os.WriteFile(filepath.Join(mapPath, "rawBuildings.json"), request.Buildings, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write rawBuildings.json: %w", err)
+	}
+	if err := writeJSONFile(filepath.Join(mapPath, "mapConfig.json"), mapConfig); err != nil {
+		return nil, fmt.Errorf("failed to write mapConfig.json: %w", err)
+	}
+	if err := writeJSONFile(filepath.Join(mapPath, "mapData.json"), mapData); err != nil {
+		return nil, fmt.Errorf("failed to write mapData.json: %w", err)
+	}
+	report(0.05)
+
+	err := calculations.CalculateWallsMatrix3D(mapPath, mapConfig, func(done float64) {
+		report(0.05 + 0.9*done)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("preprocessing failed: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	entry := Map{
+		ID:          request.ID,
+		Name:        request.Name,
+		Description: request.Description,
+		Size:        strconv.Itoa(size),
+	}
+	if err := registerMap(cwd, entry); err != nil {
+		return nil, fmt.Errorf("failed to register map: %w", err)
+	}
+	if _, err := saveMapToDB(ctx, cwd, request.ID); err != nil && !errors.Is(err, db.ErrUnavailable) {
+		log.Printf("Failed to store map %s in the database: %v", request.ID, err)
+	}
+	succeeded = true
+	return entry, nil
+}
+
+// ImportMap creates a new map from a GeoJSON FeatureCollection of buildings
+// and a bounding box. The preprocessing runs as a job, its progress and
+// errors are reported by GetMapImportJob. Once the job is done the map is
+// listed by GetMaps.
+func ImportMap(context *gin.Context) {
+	var request MapImportRequest
+	if err := context.ShouldBindJSON(&request); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := request.validate(); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Println(err)
+	}
+	registered, err := isMapRegistered(cwd, request.ID)
+	if err != nil {
+		log.Println("Failed to read maps.json:", err)
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read data file"})
+		return
+	}
+	if _, err := os.Stat(filepath.Join(cwd, "data", request.ID)); registered || err == nil {
+		context.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Map %s already exists", request.ID)})
+		return
+	}
+
+	job := mapImportJobs().Submit(request.ID, func(ctx stdcontext.Context, report func(float64)) (any, error) {
+		return importMap(ctx, cwd, request, report)
+	})
+	context.JSON(http.StatusAccepted, job)
+}
+
+func GetMapImportJob(context *gin.Context) {
+	job, ok := mapImportJobs().Get(context.Param("jobId"))
+	if !ok {
+		context.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	context.JSON(http.StatusOK, job)
+}
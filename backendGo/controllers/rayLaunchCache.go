@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"backendGo/cache"
+	"backendGo/utils/calculations"
+	"backendGo/utils/raylaunching"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	rayLaunchCacheInstance *cache.Cache
+	rayLaunchCacheOnce     sync.Once
+
+	// fileHashes remembers the hashes of map files, a file is hashed again
+	// only when its size or modification time changes.
+	fileHashes   = map[string]fileHash{}
+	fileHashesMu sync.Mutex
+)
+
+// mapGeometryFiles are the files in data/<mapTitle> a ray launching result
+// depends on.
+var mapGeometryFiles = []string{
+	"wallsMatrix3D_floor.bin",
+	"wallNormals3D.bin",
+	"wallInfo3D.bin",
+	"buildingMap2D.bin",
+	"buildings.json",
+	"mapConfig.json",
+}
+
+type fileHash struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// cachedRayLaunch is a finished ray launching run, simulationID is the id of
+// its stored simulation, 0 if it was not stored.
+type cachedRayLaunch struct {
+	result       *raylaunching.MultiStationResult
+	simulationID int
+}
+
+// rayLaunchCache keeps the results of recent runs, RAY_LAUNCH_CACHE_MB (1024
+// by default, 0 disables the cache) bounds their estimated memory.
+func rayLaunchCache() *cache.Cache {
+	rayLaunchCacheOnce.Do(func() {
+		rayLaunchCacheInstance = cache.NewFromEnv("RAY_LAUNCH_CACHE_MB", 1024)
+	})
+	return rayLaunchCacheInstance
+}
+
+func hashFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	fileHashesMu.Lock()
+	cached, ok := fileHashes[path]
+	fileHashesMu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.hash, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	fileHashesMu.Lock()
+	fileHashes[path] = fileHash{size: info.Size(), modTime: info.ModTime(), hash: hash}
+	fileHashesMu.Unlock()
+	return hash, nil
+}
+
+// geometryHash hashes the geometry files of the map and the material library.
+// Missing files are part of the hash, since runs fall back to defaults then.
+func geometryHash(cwd, mapTitle string) (string, error) {
+	paths := []string{filepath.Join(cwd, "data", "materials.json")}
+	for _, name := range mapGeometryFiles {
+		paths = append(paths, filepath.Join(cwd, "data", mapTitle, name))
+	}
+	hasher := sha256.New()
+	for _, path := range paths {
+		hash, err := hashFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			hash = "missing"
+		} else if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hasher, "%s %s\n", filepath.Base(path), hash)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// rayLaunchCacheKey hashes everything the result of run depends on: the
+// geometry of the map and the normalized request, that is the prepared
+// configuration and stations rather than the request as sent.
+func rayLaunchCacheKey(cwd, mapTitle string, run *rayLaunchRun) (string, error) {
+	geometry, err := geometryHash(cwd, mapTitle)
+	if err != nil {
+		return "", err
+	}
+	config := run.config
+	config.Materials = nil // loaded from the hashed files
+	if config.Aggregation == "" {
+		config.Aggregation = raylaunching.AggregationMax
+	}
+	key, err := json.Marshal(struct {
+		MapTitle   string
+		Geometry   string
+		Grid       calculations.MapGrid
+		Config     raylaunching.RayLaunching3DConfig
+		Stations   []raylaunching.Station
+		NoiseFloor float64
+	}{mapTitle, geometry, run.grid, config, run.stations, run.noiseFloor})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func cubeSize[T any](cube [][][]T, elementSize int64) int64 {
+	if len(cube) == 0 || len(cube[0]) == 0 {
+		return 0
+	}
+	return int64(len(cube)*len(cube[0])*len(cube[0][0])) * elementSize
+}
+
+// resultSize estimates the memory of result in bytes.
+func resultSize(result *raylaunching.MultiStationResult) int64 {
+	size := cubeSize(result.PowerMap, 8) + cubeSize(result.BestServerMap, 8) +
+		cubeSize(result.MaxRSRPMap, 8) + cubeSize(result.SINRMap, 8)
+	for _, station := range result.Stations {
+		size += cubeSize(station.PowerMap, 8) + cubeSize(station.IndoorMap, 1) +
+			cubeSize(station.MeanExcessDelayMap, 8) + cubeSize(station.RMSDelaySpreadMap, 8)
+		for _, path := range station.RayPaths {
+			size += int64(len(path)) * 32
+		}
+	}
+	return size
+}
+
+// cachedResult returns the result of an earlier run identical to run.
+func (run *rayLaunchRun) cachedResult() (*cachedRayLaunch, bool) {
+	if run.cacheKey == "" {
+		return nil, false
+	}
+	value, ok := rayLaunchCache().Get(run.cacheKey)
+	if !ok {
+		return nil, false
+	}
+	return value.(*cachedRayLaunch), true
+}
+
+// cacheResult keeps result for later runs identical to run.
+func (run *rayLaunchRun) cacheResult(result *raylaunching.MultiStationResult, simulationID int) {
+	if run.cacheKey == "" {
+		return
+	}
+	rayLaunchCache().Add(run.cacheKey, &cachedRayLaunch{result: result, simulationID: simulationID}, resultSize(result))
+}
@@ -0,0 +1,296 @@
+package controllers
+
+import (
+	"backendGo/jobs"
+	. "backendGo/types"
+	"backendGo/utils/calculations"
+	"backendGo/utils/raylaunching"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	rayLaunchJobManager     *jobs.Manager
+	rayLaunchJobManagerOnce sync.Once
+)
+
+// rayLaunchJobs creates the job manager on first use, so that settings from
+// .env (loaded by db.ConnectDB) are already in the environment.
+func rayLaunchJobs() *jobs.Manager {
+	rayLaunchJobManagerOnce.Do(func() {
+		rayLaunchJobManager = jobs.NewManagerFromEnv()
+	})
+	return rayLaunchJobManager
+}
+
+// rayLaunchResult is the result of a ray launching job, the response sent to
+// clients together with the simulation it was made from.
+type rayLaunchResult struct {
+	response gin.H
+	result   *raylaunching.MultiStationResult
+}
+
+func GetRayLaunchJob(context *gin.Context) {
+	job, ok := rayLaunchJobs().Get(context.Param("jobId"))
+	if !ok {
+		context.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	context.JSON(http.StatusOK, job)
+}
+
+func CancelRayLaunchJob(context *gin.Context) {
+	job, ok := rayLaunchJobs().Cancel(context.Param("jobId"))
+	if !ok {
+		context.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	context.JSON(http.StatusOK, job)
+}
+
+// GetRayLaunchJobResult returns the response of a finished ray launching or
+// drive test job.
+func GetRayLaunchJobResult(context *gin.Context) {
+	result, job, ok := finishedJobResult(context, context.Param("jobId"))
+	if !ok {
+		return
+	}
+	switch finished := result.(type) {
+	case *rayLaunchResult:
+		context.JSON(http.StatusOK, finished.response)
+	case *driveTestResult:
+		context.JSON(http.StatusOK, finished.response)
+	default:
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Job has no result", "job": job})
+	}
+}
+
+// finishedRayLaunchJobResult returns the result of a successfully finished ray
+// launching job. Otherwise it writes the error response and returns false.
+func finishedRayLaunchJobResult(context *gin.Context, jobId string) (*rayLaunchResult, bool) {
+	result, job, ok := finishedJobResult(context, jobId)
+	if !ok {
+		return nil, false
+	}
+	switch finished := result.(type) {
+	case *rayLaunchResult:
+		return finished, true
+	case *driveTestResult:
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Job is a drive test, not a ray launching job", "job": job})
+	default:
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Job has no result", "job": job})
+	}
+	return nil, false
+}
+
+// finishedJobResult returns the result of a successfully finished job.
+// Otherwise it writes the error response and returns false.
+func finishedJobResult(context *gin.Context, jobId string) (any, jobs.Job, bool) {
+	result, job, ok := rayLaunchJobs().Result(jobId)
+	if !ok {
+		context.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return nil, job, false
+	}
+	switch job.Status {
+	case jobs.StatusDone:
+		return result, job, true
+	case jobs.StatusFailed:
+		context.JSON(http.StatusInternalServerError, gin.H{"error": job.Error, "job": job})
+	case jobs.StatusCancelled:
+		context.JSON(http.StatusGone, gin.H{"error": "Job was cancelled", "job": job})
+	default:
+		context.JSON(http.StatusConflict, gin.H{"error": "Job has not finished yet", "job": job})
+	}
+	return nil, job, false
+}
+
+// GetRayLaunchJobGeoTIFF returns the z-slice of a finished job's power map as
+// a GeoTIFF.
+func GetRayLaunchJobGeoTIFF(context *gin.Context) {
+	jobId := context.Param("jobId")
+	z, err := strconv.Atoi(context.Param("z"))
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid z"})
+		return
+	}
+	finished, ok := finishedRayLaunchJobResult(context, jobId)
+	if !ok {
+		return
+	}
+	job, _ := rayLaunchJobs().Get(jobId)
+	response := finished.response
+	powerMap, ok := response["powerMap"].([][][]float64)
+	if !ok {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Job result has no power map"})
+		return
+	}
+	if z < 0 || z >= len(powerMap) {
+		context.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("z must be between 0 and %d", len(powerMap)-1)})
+		return
+	}
+	grid, ok := response["grid"].(calculations.MapGrid)
+	if !ok {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Job result has no map grid"})
+		return
+	}
+	var buf bytes.Buffer
+	if err := calculations.WriteGeoTIFF(&buf, powerMap[z], grid, calculations.WallMapNumber); err != nil {
+		log.Println("Failed to write GeoTIFF:", err)
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write GeoTIFF"})
+		return
+	}
+	context.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s_%d.tif", job.MapTitle, z))
+	context.Data(http.StatusOK, "image/tiff", buf.Bytes())
+}
+
+// GetRayLaunchJobRayPaths returns the target rays (singleRays) of a finished
+// job's stations as a GeoJSON FeatureCollection of LineStrings with a vertex
+// per interaction.
+func GetRayLaunchJobRayPaths(context *gin.Context) {
+	finished, ok := finishedRayLaunchJobResult(context, context.Param("jobId"))
+	if !ok {
+		return
+	}
+	response := finished.response
+	grid, hasGrid := response["grid"].(calculations.MapGrid)
+	stations, hasStations := response["stations"].([]gin.H)
+	singleRays, _ := response["singleRays"].([]SingleRay)
+	if !hasGrid || !hasStations {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Job result has no ray paths"})
+		return
+	}
+	branches := make([][][]raylaunching.RayBranch, len(stations))
+	for i, station := range stations {
+		branches[i], _ = station["rayBranches"].([][]raylaunching.RayBranch)
+	}
+	collection := raylaunching.RayBranchesGeoJSON(branches, singleRays, func(x, y, z float64) [3]float64 {
+		lat, lon := grid.ProcessedToGeo(x, y)
+		return [3]float64{lon, lat, z * grid.MetresPerLevel}
+	})
+	data, err := json.Marshal(collection)
+	if err != nil {
+		log.Println("Failed to write GeoJSON:", err)
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write GeoJSON"})
+		return
+	}
+	context.Data(http.StatusOK, "application/geo+json", data)
+}
+
+// CoverageStatisticsRequest selects the power levels (thresholds, or bins of
+// binWidth dB) and the z-levels of a job's coverage statistics.
+type CoverageStatisticsRequest struct {
+	Thresholds []float64 `json:"thresholds" binding:"omitempty,max=200"`
+	BinWidth   float64   `json:"binWidth" binding:"omitempty,gte=0.1,lte=100"`
+	ZLevels    []int     `json:"zLevels" binding:"omitempty,max=1000"`
+}
+
+// GetRayLaunchJobStatistics returns the coverage, histograms, CDFs and
+// percentiles of the received power over the outdoor voxels of a finished
+// job's power map, per requested z-level and in total.
+func GetRayLaunchJobStatistics(context *gin.Context) {
+	var request CoverageStatisticsRequest
+	if err := context.ShouldBindJSON(&request); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(request.Thresholds) > 0 && request.BinWidth > 0 {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Only one of thresholds and binWidth may be given"})
+		return
+	}
+	finished, ok := finishedRayLaunchJobResult(context, context.Param("jobId"))
+	if !ok {
+		return
+	}
+	result := finished.result
+	for _, z := range request.ZLevels {
+		if z < 0 || z >= len(result.PowerMap) {
+			context.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("z must be between 0 and %d", len(result.PowerMap)-1)})
+			return
+		}
+	}
+	var indoorMap [][][]bool
+	if len(result.Stations) > 0 {
+		indoorMap = result.Stations[0].IndoorMap
+	}
+	report := raylaunching.CoverageStatistics(result.PowerMap, indoorMap, calculations.WallMapNumber, raylaunching.CoverageOptions{
+		Thresholds: request.Thresholds,
+		BinWidth:   request.BinWidth,
+		ZLevels:    request.ZLevels,
+	})
+	context.JSON(http.StatusOK, report)
+}
+
+// HeatmapRequest holds the query parameters of a rendered heatmap, the range
+// defaults to -160..0.
+type HeatmapRequest struct {
+	Colormap  string   `form:"colormap" binding:"omitempty,oneof=jet viridis discrete"`
+	Min       *float64 `form:"min" binding:"omitempty,gte=-300,lte=100"`
+	Max       *float64 `form:"max" binding:"omitempty,gte=-300,lte=100"`
+	Bands     int      `form:"bands" binding:"omitempty,min=2,max=32"`
+	Buildings string   `form:"buildings" binding:"omitempty,oneof=opaque transparent hidden"`
+	Colorbar  bool     `form:"colorbar"`
+	Scale     int      `form:"scale" binding:"omitempty,min=1,max=8"`
+}
+
+// writeHeatmap renders the z-slice (the z path parameter) of powerMap as a
+// PNG with the options of the query string.
+func writeHeatmap(context *gin.Context, powerMap [][][]float64) {
+	z, err := strconv.Atoi(context.Param("z"))
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid z"})
+		return
+	}
+	if z < 0 || z >= len(powerMap) {
+		context.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("z must be between 0 and %d", len(powerMap)-1)})
+		return
+	}
+	var request HeatmapRequest
+	if err := context.ShouldBindQuery(&request); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	options := calculations.HeatmapOptions{
+		Colormap:  request.Colormap,
+		Min:       -160,
+		Max:       0,
+		Bands:     request.Bands,
+		Buildings: request.Buildings,
+		Colorbar:  request.Colorbar,
+		Scale:     request.Scale,
+	}
+	if request.Min != nil {
+		options.Min = *request.Min
+	}
+	if request.Max != nil {
+		options.Max = *request.Max
+	}
+	if options.Min >= options.Max {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "min must be less than max"})
+		return
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, calculations.RenderHeatmap(powerMap[z], options)); err != nil {
+		log.Println("Failed to write heatmap:", err)
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write heatmap"})
+		return
+	}
+	context.Data(http.StatusOK, "image/png", buf.Bytes())
+}
+
+// GetRayLaunchJobHeatmap returns the z-slice of a finished job's power map as
+// a PNG, see HeatmapRequest for the options.
+func GetRayLaunchJobHeatmap(context *gin.Context) {
+	finished, ok := finishedRayLaunchJobResult(context, context.Param("jobId"))
+	if !ok {
+		return
+	}
+	writeHeatmap(context, finished.result.PowerMap)
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func jobRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/rayLaunch/jobs/:jobId/result", GetRayLaunchJobResult)
+	router.GET("/rayLaunch/jobs/:jobId/geotiff/:z", GetRayLaunchJobGeoTIFF)
+	return router
+}
+
+func getJSON(t *testing.T, router *gin.Engine, path string) (int, map[string]any) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	var body map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("GET %s: invalid JSON %q: %v", path, recorder.Body.String(), err)
+	}
+	return recorder.Code, body
+}
+
+func TestGetRayLaunchJobResult(t *testing.T) {
+	router := jobRouter()
+	rayLaunch := rayLaunchJobs().Done("test", &rayLaunchResult{response: gin.H{"mapTitle": "test", "stations": []gin.H{}}})
+	driveTest := rayLaunchJobs().Done("test", &driveTestResult{response: gin.H{"mapTitle": "test", "stats": gin.H{"count": 2}}})
+	empty := rayLaunchJobs().Done("test", nil)
+
+	for _, test := range []struct {
+		name   string
+		jobId  string
+		status int
+		key    string
+	}{
+		{"ray launching job", rayLaunch.ID, http.StatusOK, "stations"},
+		{"drive test job", driveTest.ID, http.StatusOK, "stats"},
+		{"job without result", empty.ID, http.StatusInternalServerError, "error"},
+		{"unknown job", "missing", http.StatusNotFound, "error"},
+	} {
+		status, body := getJSON(t, router, "/rayLaunch/jobs/"+test.jobId+"/result")
+		if status != test.status {
+			t.Errorf("%s: status %d, want %d (%v)", test.name, status, test.status, body)
+		}
+		if _, ok := body[test.key]; !ok {
+			t.Errorf("%s: response %v has no %q", test.name, body, test.key)
+		}
+	}
+
+	// the ray launching endpoints reject drive test jobs
+	status, body := getJSON(t, router, "/rayLaunch/jobs/"+driveTest.ID+"/geotiff/0")
+	if status != http.StatusBadRequest {
+		t.Errorf("GeoTIFF of a drive test job: status %d, want %d (%v)", status, http.StatusBadRequest, body)
+	}
+}
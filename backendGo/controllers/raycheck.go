@@ -1,17 +1,16 @@
 package controllers
 
 import (
+	"backendGo/db"
 	. "backendGo/types"
 	"backendGo/utils/calculations"
 	"backendGo/utils/raylaunching"
+	stdcontext "context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"image"
-	"image/color"
-	"image/draw"
-	"image/gif"
-	"image/png"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -38,6 +37,9 @@ type MapConfiguration struct {
 	Center      [2]float64    `json:"center"`
 	Bounds      [2][2]float64 `json:"bounds"`
 	Size        int           `json:"size"`
+	// Grid describes the cells of the map's matrices, maps without it in
+	// mapData.json get it from mapConfig.json.
+	Grid *calculations.MapGrid `json:"grid,omitempty"`
 }
 
 type Features struct {
@@ -70,7 +72,6 @@ func GetMaps(context *gin.Context) {
 		context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse JSON"})
 		return
 	}
-	print(mapsData)
 	context.JSON(http.StatusOK, mapsData)
 }
 
@@ -100,6 +101,15 @@ func GetMapById(context *gin.Context) {
 		return
 	}
 
+	if mapData.Grid == nil {
+		if mapConfig, err := loadMapConfig(cwd, mapTitle); err == nil {
+			grid := calculations.NewMapGrid(mapConfig)
+			mapData.Grid = &grid
+		} else {
+			log.Println("Failed to read map config:", err)
+		}
+	}
+
 	data, err = os.ReadFile(filepath.Join(cwd, "data", mapTitle, "rawBuildings.json"))
 	if err != nil {
 		log.Println("Failed to read data file")
@@ -125,18 +135,64 @@ func GetMapById(context *gin.Context) {
 	context.JSON(http.StatusOK, response)
 }
 
+type StationRequest struct {
+	Pos       Point3D  `json:"pos" binding:"required"`
+	Power     float64  `json:"power" binding:"required,gte=0.1,lte=100"`
+	Frequency float64  `json:"frequency" binding:"required,gte=0.1,lte=100"`
+	Antenna   *Antenna `json:"antenna" binding:"omitempty"`
+}
+
+// defaultNoiseFloor is the thermal noise over a 10 MHz channel.
+const defaultNoiseFloor = -104.0
+
 type RayLaunchRequest struct {
-	NumberOfRaysAzimuth   int         `json:"numberOfRaysAzimuth" binding:"required,min=1,max=1440"`
-	NumberOfRaysElevation int         `json:"numberOfRaysElevation" binding:"required,min=1,max=1440"`
-	NumberOfInteractions  int         `json:"numberOfInteractions" binding:"required,min=1,max=10"`
-	ReflectionFactor      float64     `json:"reflectionFactor" binding:"required,gte=0,lte=1"`
-	StationPower          float64     `json:"stationPower" binding:"required,gte=0.1,lte=100"`
-	MinimalRayPower       float64     `json:"minimalRayPower" binding:"required,gte=-160,lte=-60"`
-	Frequency             float64     `json:"frequency" binding:"required,gte=0.1,lte=100"`
-	Size                  int         `json:"size" binding:"required,oneof=250 400 500"`
-	StationPos            Point3D     `json:"stationPos" binding:"required"`
-	SingleRays            []SingleRay `json:"singleRays" binding:"omitempty,dive,required"`
-	DiffractionRayNumber  int         `json:"diffractionRayNumber" binding:"required,min=1,max=120"`
+	NumberOfRaysAzimuth   int              `json:"numberOfRaysAzimuth" binding:"required,min=1,max=1440"`
+	NumberOfRaysElevation int              `json:"numberOfRaysElevation" binding:"required,min=1,max=1440"`
+	NumberOfInteractions  int              `json:"numberOfInteractions" binding:"required,min=1,max=10"`
+	ReflectionFactor      float64          `json:"reflectionFactor" binding:"required,gte=0,lte=1"`
+	StationPower          float64          `json:"stationPower" binding:"omitempty,gte=0.1,lte=100"`
+	MinimalRayPower       float64          `json:"minimalRayPower" binding:"required,gte=-160,lte=-60"`
+	Frequency             float64          `json:"frequency" binding:"omitempty,gte=0.1,lte=100"`
+	CellSize              float64          `json:"cellSize" binding:"omitempty,gt=0,lte=100"`    // metres, a multiple of the map's cell size for a coarser run
+	LevelHeight           float64          `json:"levelHeight" binding:"omitempty,gt=0,lte=100"` // metres, a multiple of the map's level height
+	StationPos            *Point3D         `json:"stationPos" binding:"omitempty"`
+	Antenna               *Antenna         `json:"antenna" binding:"omitempty"`
+	Stations              []StationRequest `json:"stations" binding:"omitempty,max=16,dive"`
+	NoiseFloor            *float64         `json:"noiseFloor" binding:"omitempty,gte=-200,lte=0"`
+	WallPenetration       *bool            `json:"wallPenetration"`
+	SingleRays            []SingleRay      `json:"singleRays" binding:"omitempty,dive,required"`
+	DiffractionRayNumber  int              `json:"diffractionRayNumber" binding:"required,min=1,max=120"`
+	Aggregation           string           `json:"aggregation" binding:"omitempty,oneof=max incoherent coherent"`
+	DelaySpread           bool             `json:"delaySpread"`
+	Receivers             []Point3D        `json:"receivers" binding:"omitempty,max=100"`
+	DelayResolution       float64          `json:"delayResolution" binding:"omitempty,gt=0,lte=1000"` // ns, bin width of the power delay profiles
+	AngleResolution       float64          `json:"angleResolution" binding:"omitempty,gt=0,lte=90"`   // degrees, bin width of the angular power spectra
+}
+
+// stations returns the stations of the request. Requests without a stations
+// array describe a single station with stationPos, stationPower and frequency.
+func (request *RayLaunchRequest) stations() ([]raylaunching.Station, error) {
+	if len(request.Stations) == 0 {
+		if request.StationPos == nil || request.StationPower == 0 || request.Frequency == 0 {
+			return nil, fmt.Errorf("either stations or stationPos, stationPower and frequency are required")
+		}
+		return []raylaunching.Station{{
+			Pos:       *request.StationPos,
+			Power:     request.StationPower,    //watt
+			Frequency: request.Frequency * 1e9, // Hz
+			Antenna:   request.Antenna,
+		}}, nil
+	}
+	stations := make([]raylaunching.Station, len(request.Stations))
+	for i, station := range request.Stations {
+		stations[i] = raylaunching.Station{
+			Pos:       station.Pos,
+			Power:     station.Power,           //watt
+			Frequency: station.Frequency * 1e9, // Hz
+			Antenna:   station.Antenna,
+		}
+	}
+	return stations, nil
 }
 
 func Create3DRayLaunching(context *gin.Context) {
@@ -147,18 +203,163 @@ func Create3DRayLaunching(context *gin.Context) {
 		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	run, ok := newRayLaunchRun(context, mapTitle, request)
+	if !ok {
+		return
+	}
+
+	if cached, ok := run.cachedResult(); ok {
+		// an identical request was answered before, its job is done already
+		job := rayLaunchJobs().Done(mapTitle, &rayLaunchResult{
+			response: rayLaunchResponse(mapTitle, run, cached, true),
+			result:   cached.result,
+		})
+		context.JSON(http.StatusOK, job)
+		return
+	}
+
+	job := rayLaunchJobs().Submit(mapTitle, func(ctx stdcontext.Context, report func(float64)) (any, error) {
+		startedAt := time.Now()
+		result, err := run.calculate(ctx, report)
+		if err != nil {
+			return nil, err
+		}
+		simulationID, err := persistSimulation(mapTitle, request, run, result, startedAt)
+		if err != nil && !errors.Is(err, db.ErrUnavailable) {
+			log.Println("Failed to store simulation:", err)
+		}
+		run.cacheResult(result, simulationID)
+		return &rayLaunchResult{
+			response: rayLaunchResponse(mapTitle, run, &cachedRayLaunch{result: result, simulationID: simulationID}, false),
+			result:   result,
+		}, nil
+	})
+
+	context.JSON(http.StatusAccepted, job)
+}
+
+// rayLaunchResponse is the result of a ray launching job, cached tells whether
+// it was taken from the cache of earlier runs.
+func rayLaunchResponse(mapTitle string, run *rayLaunchRun, finished *cachedRayLaunch, cached bool) gin.H {
+	result := finished.result
+	stationResults := make([]gin.H, len(result.Stations))
+	for i, station := range result.Stations {
+		stationResults[i] = gin.H{
+			"stationPos":     station.Config.TransmitterPos,
+			"antenna":        station.Config.Antenna,
+			"rayPaths":       station.RayPaths,
+			"rayBranches":    station.RayBranches,
+			"powerMapLegend": station.PowerMapLegend,
+
+			"meanExcessDelayMap": station.MeanExcessDelayMap,
+			"rmsDelaySpreadMap":  station.RMSDelaySpreadMap,
+			"powerDelayProfiles": station.PowerDelayProfiles,
+			"receiverAngles":     station.ReceiverAngles,
+		}
+	}
+	response := gin.H{
+		"message":        "Request received successfully",
+		"mapTitle":       mapTitle,
+		"grid":           run.grid,
+		"stationPos":     run.stations[0].Pos,
+		"powerMap":       result.PowerMap,
+		"rayPaths":       result.Stations[0].RayPaths,
+		"rayBranches":    result.Stations[0].RayBranches,
+		"singleRays":     run.config.SingleRays,
+		"powerMapLegend": result.PowerMapLegend,
+		"bestServerMap":  result.BestServerMap,
+		"maxRsrpMap":     result.MaxRSRPMap,
+		"sinrMap":        result.SINRMap,
+		"stations":       stationResults,
+		"cached":         cached,
+
+		"meanExcessDelayMap": result.Stations[0].MeanExcessDelayMap,
+		"rmsDelaySpreadMap":  result.Stations[0].RMSDelaySpreadMap,
+		"powerDelayProfiles": result.Stations[0].PowerDelayProfiles,
+		"receiverAngles":     result.Stations[0].ReceiverAngles,
+	}
+	if finished.simulationID != 0 {
+		// the stored simulation outlives the job, see GetSimulation
+		response["simulationId"] = finished.simulationID
+	}
+	return response
+}
+
+// rayLaunchRun is a ray launching request with the geometry of its map loaded.
+// The geometry, the stations and the results use grid, which is the grid of
+// the map resampled to the resolution of the request.
+type rayLaunchRun struct {
+	grid        calculations.MapGrid
+	matrix      [][][]float64
+	wallNormals []Normal3D
+	config      raylaunching.RayLaunching3DConfig
+	stations    []raylaunching.Station
+	noiseFloor  float64
+	// cacheKey identifies the result in rayLaunchCache, empty if the run
+	// cannot be cached.
+	cacheKey string
+}
+
+// newRayLaunchRun prepares request for mapTitle. On failure it writes the
+// error response and returns false.
+func newRayLaunchRun(context *gin.Context, mapTitle string, request RayLaunchRequest) (*rayLaunchRun, bool) {
+	stations, err := request.stations()
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	noiseFloor := defaultNoiseFloor
+	if request.NoiseFloor != nil {
+		noiseFloor = *request.NoiseFloor
+	}
 	log.Printf("Received request: %+v\n", request)
 	cwd, err := os.Getwd()
 	if err != nil {
 		log.Println(err)
 	}
 	var matrixInt [][][]int16
-	// fmt.Printf("Single rays: %v", request.SingleRays)
 	err = calculations.LoadMatrixBinary(filepath.Join(cwd, "data", mapTitle, "wallsMatrix3D_floor.bin"), &matrixInt)
 	if err != nil {
 		log.Println("Failed to load matrix:", err)
 		context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load matrix"})
-		return
+		return nil, false
+	}
+	if len(matrixInt) == 0 || len(matrixInt[0]) == 0 {
+		log.Println("Empty matrix for map", mapTitle)
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load matrix"})
+		return nil, false
+	}
+	mapConfig, err := loadMapConfig(cwd, mapTitle)
+	if err != nil {
+		log.Println("Failed to read map config, assuming a grid of 1 m cells:", err)
+		mapConfig = MapConfig{Size: len(matrixInt[0])}
+	}
+	grid := calculations.NewMapGrid(mapConfig)
+	grid.SizeZ = len(matrixInt)
+	stride, err := resampleStride("cellSize", request.CellSize, grid.CellSize())
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	levelStride, err := resampleStride("levelHeight", request.LevelHeight, grid.MetresPerLevel)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	materials := loadMapMaterials(cwd, mapTitle)
+	receivers := append([]Point3D(nil), request.Receivers...)
+	if stride > 1 || levelStride > 1 {
+		log.Printf("Resampling map %s by %d cells and %d levels\n", mapTitle, stride, levelStride)
+		resampled := grid.Resample(stride, levelStride)
+		for i := range stations {
+			stations[i].Pos = resampledPosition(stations[i].Pos, grid, stride, levelStride)
+		}
+		for i := range receivers {
+			receivers[i] = resampledPosition(receivers[i], grid, stride, levelStride)
+		}
+		matrixInt = calculations.ResampleProcessedMatrix3D(matrixInt, stride, levelStride)
+		materials.BuildingMap = calculations.ResampleBuildingMap(materials.BuildingMap, stride)
+		grid = resampled
 	}
 	matrix := calculations.ConvertInt16MatrixToFloat64(matrixInt)
 	var wallNormals []Normal3D
@@ -166,7 +367,7 @@ func Create3DRayLaunching(context *gin.Context) {
 	if err != nil {
 		log.Println("Failed to load matrix:", err)
 		context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load matrix"})
-		return
+		return nil, false
 	}
 	config := raylaunching.RayLaunching3DConfig{
 		NumOfRaysAzim:         request.NumberOfRaysAzimuth,
@@ -177,104 +378,109 @@ func Create3DRayLaunching(context *gin.Context) {
 		CornerMapNumber:       10000,
 		RoofCornerMapNumber:   10001,
 		BuldingInteriorNumber: 20000,
-		SizeX:                 float64(request.Size - 1),
-		SizeY:                 float64(request.Size - 1),
-		SizeZ:                 30 - 1,
+		SizeX:                 float64(len(matrix[0][0]) - 1),
+		SizeY:                 float64(len(matrix[0]) - 1),
+		SizeZ:                 float64(len(matrix) - 1),
+		CellSize:              grid.MetresPerCellX,
+		CellSizeY:             grid.MetresPerCellY,
+		LevelHeight:           grid.MetresPerLevel,
+		Aggregation:           request.Aggregation,
+		DelaySpread:           request.DelaySpread,
+		Receivers:             receivers,
+		DelayResolution:       request.DelayResolution,
+		AngleResolution:       request.AngleResolution,
 		Step:                  1.0,
 		ReflFactor:            request.ReflectionFactor,
-		TransmitterPower:      request.StationPower,    //watt
 		MinimalRayPower:       request.MinimalRayPower, //dbm
-		TransmitterFreq:       request.Frequency * 1e9, // Hz
-		WaveLength:            0,
-		TransmitterPos:        Point3D{X: request.StationPos.X, Y: request.StationPos.Y, Z: request.StationPos.Z},
 		SingleRays:            request.SingleRays,
 		DiffractionRayNumber:  request.DiffractionRayNumber,
+		Materials:             materials,
+		WallPenetration:       request.WallPenetration != nil && *request.WallPenetration,
 	}
-	config.WaveLength = 299792458 / (config.TransmitterFreq)
-	start := time.Now()
-	rayLaunching := raylaunching.NewRayLaunching3D(matrix, wallNormals, config)
-	rayLaunching.CalculateRayLaunching3D()
-	// TESTING - START
+	run := &rayLaunchRun{
+		grid:        grid,
+		matrix:      matrix,
+		wallNormals: wallNormals,
+		config:      config,
+		stations:    stations,
+		noiseFloor:  noiseFloor,
+	}
+	if run.cacheKey, err = rayLaunchCacheKey(cwd, mapTitle, run); err != nil {
+		log.Println("Failed to hash the map, the result will not be cached:", err)
+	}
+	return run, true
+}
 
-	// fmt.Printf("RayPaths: %v", rayLaunching.RayPaths)
+func (run *rayLaunchRun) calculate(ctx stdcontext.Context, report func(float64)) (*raylaunching.MultiStationResult, error) {
+	start := time.Now()
+	result, err := raylaunching.CalculateMultiStation3D(ctx, run.matrix, run.wallNormals, run.config, run.stations, run.noiseFloor, func(done, total int) {
+		report(float64(done) / float64(total))
+	})
+	if err != nil {
+		return nil, err
+	}
 	stop := time.Since(start)
 	fmt.Printf("RayLaunching 3D calculation time: %v\n", stop)
-	outputDir := filepath.Join("data", mapTitle, "imgs")
-	err = os.MkdirAll(outputDir, os.ModePerm)
-	if err != nil {
-		log.Fatalf("failed to create output directory: %v", err)
+	return result, nil
+}
+
+// resampleStride returns the number of stored cells of size stored that make
+// up a cell of the requested size, 1 when no size is requested.
+func resampleStride(name string, requested, stored float64) (int, error) {
+	if requested == 0 {
+		return 1, nil
 	}
-	for i := 0; i <= int(config.SizeZ); i++ {
-		heatmap := calculations.GenerateHeatmap(rayLaunching.PowerMap[i])
-		filename := filepath.Join(outputDir, fmt.Sprintf("heatmap_%d.png", i))
-		f, err := os.Create(filename)
-		if err != nil {
-			log.Printf("failed to create file %s: %v", filename, err)
-			continue
-		}
-		err = png.Encode(f, heatmap)
-		if err != nil {
-			log.Printf("failed to encode image %s: %v", filename, err)
-		}
-		f.Close()
+	stride := math.Round(requested / stored)
+	if stride < 1 || math.Abs(requested-stride*stored) > 1e-6*stored {
+		return 0, fmt.Errorf("%s must be a multiple of the %g m of the map", name, stored)
 	}
+	return int(stride), nil
+}
 
-	outGif := &gif.GIF{}
-	for i := 0; i <= int(config.SizeZ); i++ {
-		filename := filepath.Join(outputDir, fmt.Sprintf("heatmap_%d.png", i))
-		f, err := os.Open(filename)
-		if err != nil {
-			log.Printf("failed to open file %s: %v", filename, err)
-			continue
-		}
+// resampledPosition converts a position in the processed matrix of grid (row
+// 0 at the north) to the grid resampled by stride and levelStride.
+func resampledPosition(pos Point3D, grid calculations.MapGrid, stride, levelStride int) Point3D {
+	resampled := grid.Resample(stride, levelStride)
+	south := float64(grid.SizeY-1) - pos.Y
+	return Point3D{
+		X: math.Round(pos.X / float64(stride)),
+		Y: float64(resampled.SizeY-1) - math.Round(south/float64(stride)),
+		Z: math.Round(pos.Z / float64(levelStride)),
+	}
+}
 
-		img, err := png.Decode(f)
-		if err != nil {
-			log.Printf("failed to decode image %s: %v", filename, err)
-			f.Close()
-			continue
-		}
-		f.Close()
+func loadMapConfig(cwd, mapTitle string) (MapConfig, error) {
+	var mapConfig MapConfig
+	data, err := os.ReadFile(filepath.Join(cwd, "data", mapTitle, "mapConfig.json"))
+	if err != nil {
+		return mapConfig, err
+	}
+	err = json.Unmarshal(data, &mapConfig)
+	return mapConfig, err
+}
 
-		bounds := img.Bounds()
-		palette := make(color.Palette, 256)
-		palette[0] = color.RGBA{0, 0, 0, 255}
-		for i := 1; i < 256; i++ {
-			palette[i] = color.RGBA{
-				uint8(i),
-				uint8(i),
-				uint8(255 - i),
-				255,
-			}
-		}
-		palettedImg := image.NewPaletted(bounds, palette)
-		draw.Draw(palettedImg, bounds, img, bounds.Min, draw.Src)
-		outGif.Image = append(outGif.Image, palettedImg)
-		outGif.Delay = append(outGif.Delay, 20)
+// loadMapMaterials reads the material library and the per wall and per
+// building material tables of a map. Maps preprocessed before materials were
+// introduced have no tables and fall back to the default materials.
+func loadMapMaterials(cwd, mapTitle string) *raylaunching.MapMaterials {
+	library, err := raylaunching.LoadMaterialLibrary(filepath.Join(cwd, "data", "materials.json"))
+	if err != nil {
+		log.Println("Failed to load material library:", err)
 	}
-	if len(outGif.Image) == 0 {
-		log.Println("No frames were added to the GIF, aborting GIF creation")
+	materials := &raylaunching.MapMaterials{Library: library}
+	mapPath := filepath.Join(cwd, "data", mapTitle)
+	if err := calculations.LoadMatrixBinary(filepath.Join(mapPath, "wallInfo3D.bin"), &materials.Walls); err != nil {
+		log.Println("No wall materials, using defaults:", err)
 	}
-	gifFilename := filepath.Join(outputDir, fmt.Sprintf("%s_animation.gif", mapTitle))
-	gifFile, err := os.Create(gifFilename)
-	if err != nil {
-		log.Printf("failed to create GIF file: %v", err)
+	if err := calculations.LoadMatrixBinary(filepath.Join(mapPath, "buildingMap2D.bin"), &materials.BuildingMap); err != nil {
+		log.Println("No building map, using default roof materials:", err)
+	}
+	data, err := os.ReadFile(filepath.Join(mapPath, "buildings.json"))
+	if err == nil {
+		err = json.Unmarshal(data, &materials.Buildings)
 	}
-	err = gif.EncodeAll(gifFile, outGif)
 	if err != nil {
-		log.Printf("failed to encode GIF: %v", err)
+		log.Println("Failed to read buildings, using default roof materials:", err)
 	}
-	gifFile.Close()
-	fmt.Printf("GIF animation created at %s\n", gifFilename)
-
-	// TESTING - END
-
-	context.JSON(http.StatusOK, gin.H{
-		"message":        "Request received successfully",
-		"mapTitle":       mapTitle,
-		"stationPos":     request.StationPos,
-		"powerMap":       rayLaunching.PowerMap,
-		"rayPaths":       rayLaunching.RayPaths,
-		"powerMapLegend": rayLaunching.PowerMapLegend,
-	})
+	return materials
 }
@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"backendGo/db"
+	. "backendGo/types"
+	"backendGo/utils/calculations"
+	"backendGo/utils/raylaunching"
+	stdcontext "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// persistMap stores the metadata, buildings and walls of the map in data/
+// mapTitle, unless the database already has it, and returns its id.
+func persistMap(ctx stdcontext.Context, cwd, mapTitle string) (int, error) {
+	id, err := db.MapID(ctx, mapTitle)
+	if !errors.Is(err, db.ErrNotFound) {
+		return id, err
+	}
+	return saveMapToDB(ctx, cwd, mapTitle)
+}
+
+// saveMapToDB stores the map in data/mapTitle, replacing a stored map with
+// the same title.
+func saveMapToDB(ctx stdcontext.Context, cwd, mapTitle string) (int, error) {
+	mapPath := filepath.Join(cwd, "data", mapTitle)
+	data, err := os.ReadFile(filepath.Join(mapPath, "mapData.json"))
+	if err != nil {
+		return 0, err
+	}
+	var mapData MapConfiguration
+	if err := json.Unmarshal(data, &mapData); err != nil {
+		return 0, fmt.Errorf("failed to parse mapData.json: %w", err)
+	}
+	mapConfig, err := loadMapConfig(cwd, mapTitle)
+	if err != nil {
+		return 0, err
+	}
+	grid := calculations.NewMapGrid(mapConfig)
+	var buildings []Building
+	data, err = os.ReadFile(filepath.Join(mapPath, "buildings.json"))
+	if err == nil {
+		err = json.Unmarshal(data, &buildings)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read buildings.json: %w", err)
+	}
+
+	record := db.MapRecord{
+		Title:  mapTitle,
+		Name:   mapData.Title,
+		Width:  grid.SizeX,
+		Height: grid.SizeY,
+		Bounds: [2][2]float64{{mapConfig.LonMin, mapConfig.LatMin}, {mapConfig.LonMax, mapConfig.LatMax}},
+	}
+	mapsFileMu.Lock()
+	maps, err := readMaps(cwd)
+	mapsFileMu.Unlock()
+	if err == nil {
+		for _, m := range maps {
+			if m.ID == mapTitle {
+				record.Name, record.Description = m.Name, m.Description
+			}
+		}
+	}
+	if record.MapConfig, err = json.Marshal(mapConfig); err != nil {
+		return 0, err
+	}
+	if record.Grid, err = json.Marshal(grid); err != nil {
+		return 0, err
+	}
+	return db.SaveMap(ctx, record, buildings)
+}
+
+// persistSimulation stores a finished run of request and returns the id of
+// the simulation, or db.ErrUnavailable when there is no database.
+func persistSimulation(mapTitle string, request RayLaunchRequest, run *rayLaunchRun, result *raylaunching.MultiStationResult, startedAt time.Time) (int, error) {
+	ctx := stdcontext.Background()
+	cwd, err := os.Getwd()
+	if err != nil {
+		return 0, err
+	}
+	mapID, err := persistMap(ctx, cwd, mapTitle)
+	if err != nil {
+		return 0, err
+	}
+	record := db.SimulationRecord{
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+		PowerMap:   result.PowerMap,
+	}
+	if record.Config, err = json.Marshal(request); err != nil {
+		return 0, err
+	}
+	if record.Grid, err = json.Marshal(run.grid); err != nil {
+		return 0, err
+	}
+	if record.Legend, err = json.Marshal(result.PowerMapLegend); err != nil {
+		return 0, err
+	}
+	return db.SaveSimulation(ctx, mapID, record)
+}
+
+// writeDBError writes the response of a failed repository call.
+func writeDBError(context *gin.Context, err error, notFound string) {
+	switch {
+	case errors.Is(err, db.ErrUnavailable):
+		context.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database is not available"})
+	case errors.Is(err, db.ErrNotFound):
+		context.JSON(http.StatusNotFound, gin.H{"error": notFound})
+	default:
+		log.Println("Database error:", err)
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read from database"})
+	}
+}
+
+// GetSimulations lists the stored simulations, of the map given by the
+// mapTitle query parameter or of all maps, without their power maps.
+func GetSimulations(context *gin.Context) {
+	simulations, err := db.ListSimulations(context.Request.Context(), context.Query("mapTitle"))
+	if err != nil {
+		writeDBError(context, err, "Simulation not found")
+		return
+	}
+	context.JSON(http.StatusOK, simulations)
+}
+
+// GetSimulation returns a stored simulation with its power map.
+func GetSimulation(context *gin.Context) {
+	id, err := strconv.Atoi(context.Param("simulationId"))
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid simulation id"})
+		return
+	}
+	simulation, err := db.GetSimulation(context.Request.Context(), id)
+	if err != nil {
+		writeDBError(context, err, "Simulation not found")
+		return
+	}
+	context.JSON(http.StatusOK, simulation)
+}
+
+// GetSimulationHeatmap returns the z-slice of a stored simulation's power map
+// as a PNG, see HeatmapRequest for the options.
+func GetSimulationHeatmap(context *gin.Context) {
+	id, err := strconv.Atoi(context.Param("simulationId"))
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid simulation id"})
+		return
+	}
+	simulation, err := db.GetSimulation(context.Request.Context(), id)
+	if err != nil {
+		writeDBError(context, err, "Simulation not found")
+		return
+	}
+	writeHeatmap(context, simulation.PowerMap)
+}
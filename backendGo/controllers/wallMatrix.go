@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	. "backendGo/types"
+	"backendGo/utils/calculations"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Wall matrix response format, all values little endian:
+//
+//	offset  size  field
+//	0       4     magic "WMAT"
+//	4       2     version (1)
+//	6       2     dtype (1 = int16)
+//	8       4     sizeX
+//	12      4     sizeY
+//	16      4     sizeZ, number of floors of the whole map
+//	20      4     zStart, first floor in the payload
+//	24      4     zCount, number of floors in the payload
+//	28      4     reserved
+//	32      ...   zCount*sizeY*sizeX values, indexed [z][y][x]
+//
+// The values are the voxel labels of the processed matrix used by the ray
+// launching (row 0 is the northern edge of the map).
+const (
+	wallMatrixMagic      = "WMAT"
+	wallMatrixVersion    = 1
+	wallMatrixDtypeInt16 = 1
+	wallMatrixHeaderSize = 32
+)
+
+// wallMatrix is the payload of a map's processed matrix together with the
+// identity of the file it was read from.
+type wallMatrix struct {
+	sizeX, sizeY, sizeZ int
+	payload             []byte // int16 values, [z][y][x]
+	version             string // changes whenever the source file changes
+}
+
+var (
+	wallMatrixCacheMu sync.Mutex
+	wallMatrixCache   = map[string]*wallMatrix{}
+)
+
+// loadWallMatrix returns the processed matrix of a map. It prefers
+// wallsMatrix3D_floor.bin, the file the ray launching reads, and falls back to
+// the raw wallsMatrix3D_processed.bin with the dimensions from mapConfig.json.
+func loadWallMatrix(cwd, mapTitle string) (*wallMatrix, error) {
+	mapPath := filepath.Join(cwd, "data", mapTitle)
+	path := filepath.Join(mapPath, "wallsMatrix3D_floor.bin")
+	info, err := os.Stat(path)
+	if err != nil {
+		path = filepath.Join(mapPath, "wallsMatrix3D_processed.bin")
+		info, err = os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	version := fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size())
+
+	wallMatrixCacheMu.Lock()
+	cached, ok := wallMatrixCache[path]
+	wallMatrixCacheMu.Unlock()
+	if ok && cached.version == version {
+		return cached, nil
+	}
+
+	var matrix [][][]int16
+	if filepath.Base(path) == "wallsMatrix3D_floor.bin" {
+		err = calculations.LoadMatrixBinary(path, &matrix)
+	} else {
+		var mapConfig MapConfig
+		mapConfig, err = loadMapConfig(cwd, mapTitle)
+		if err == nil {
+			grid := calculations.NewMapGrid(mapConfig)
+			matrix, err = calculations.LoadRawBinary3D(path, mapConfig.HeightMaxLevels, grid.SizeY, grid.SizeX)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(matrix) == 0 || len(matrix[0]) == 0 {
+		return nil, fmt.Errorf("empty wall matrix in %s", path)
+	}
+
+	loaded := &wallMatrix{sizeZ: len(matrix), sizeY: len(matrix[0]), sizeX: len(matrix[0][0]), version: version}
+	loaded.payload = make([]byte, 0, 2*loaded.sizeZ*loaded.sizeY*loaded.sizeX)
+	for _, floor := range matrix {
+		for _, row := range floor {
+			for _, value := range row {
+				loaded.payload = binary.LittleEndian.AppendUint16(loaded.payload, uint16(value))
+			}
+		}
+	}
+
+	wallMatrixCacheMu.Lock()
+	wallMatrixCache[path] = loaded
+	wallMatrixCacheMu.Unlock()
+	return loaded, nil
+}
+
+// parseFloors parses "z" or "zStart-zEnd" (inclusive) and returns the first
+// floor and the number of floors.
+func parseFloors(value string, sizeZ int) (int, int, error) {
+	if value == "" {
+		return 0, sizeZ, nil
+	}
+	startText, endText, isRange := strings.Cut(value, "-")
+	start, err := strconv.Atoi(startText)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid floors %q", value)
+	}
+	end := start
+	if isRange {
+		if end, err = strconv.Atoi(endText); err != nil {
+			return 0, 0, fmt.Errorf("invalid floors %q", value)
+		}
+	}
+	if start < 0 || end < start || end >= sizeZ {
+		return 0, 0, fmt.Errorf("floors must lie between 0 and %d", sizeZ-1)
+	}
+	return start, end - start + 1, nil
+}
+
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// GetWallMatrix serves the processed voxel geometry of a map. The optional
+// floors query parameter ("5" or "0-9") limits the response to these floors.
+// The response is gzip compressed when the client accepts it.
+func GetWallMatrix(context *gin.Context) {
+	mapTitle := context.Param("mapTitle")
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Println(err)
+	}
+	matrix, err := loadWallMatrix(cwd, mapTitle)
+	if err != nil {
+		log.Println("Failed to load wall matrix:", err)
+		context.JSON(http.StatusNotFound, gin.H{"error": "Wall matrix not found"})
+		return
+	}
+	zStart, zCount, err := parseFloors(context.Query("floors"), matrix.sizeZ)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	useGzip := strings.Contains(context.GetHeader("Accept-Encoding"), "gzip")
+	etag := fmt.Sprintf(`"%s-%d-%d"`, matrix.version, zStart, zCount)
+	if useGzip {
+		etag = fmt.Sprintf(`"%s-%d-%d-gzip"`, matrix.version, zStart, zCount)
+	}
+	context.Header("ETag", etag)
+	context.Header("Vary", "Accept-Encoding")
+	context.Header("Cache-Control", "no-cache")
+	if etagMatches(context.GetHeader("If-None-Match"), etag) {
+		context.Status(http.StatusNotModified)
+		return
+	}
+
+	header := make([]byte, wallMatrixHeaderSize)
+	copy(header, wallMatrixMagic)
+	binary.LittleEndian.PutUint16(header[4:], wallMatrixVersion)
+	binary.LittleEndian.PutUint16(header[6:], wallMatrixDtypeInt16)
+	binary.LittleEndian.PutUint32(header[8:], uint32(matrix.sizeX))
+	binary.LittleEndian.PutUint32(header[12:], uint32(matrix.sizeY))
+	binary.LittleEndian.PutUint32(header[16:], uint32(matrix.sizeZ))
+	binary.LittleEndian.PutUint32(header[20:], uint32(zStart))
+	binary.LittleEndian.PutUint32(header[24:], uint32(zCount))
+	floorSize := 2 * matrix.sizeY * matrix.sizeX
+	payload := matrix.payload[zStart*floorSize : (zStart+zCount)*floorSize]
+
+	if !useGzip {
+		context.Data(http.StatusOK, "application/octet-stream", append(header, payload...))
+		return
+	}
+	var buf bytes.Buffer
+	gz, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	gz.Write(header)
+	gz.Write(payload)
+	if err := gz.Close(); err != nil {
+		log.Println("Failed to compress wall matrix:", err)
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compress wall matrix"})
+		return
+	}
+	context.Header("Content-Encoding", "gzip")
+	context.Data(http.StatusOK, "application/octet-stream", buf.Bytes())
+}
@@ -27,5 +27,12 @@ func ConnectDB() {
 		log.Fatalf("Could not connect with db: %v", err)
 	}
 
+	if err := migrate(context.Background()); err != nil {
+		log.Printf("Could not prepare db, maps and results will not be persisted: %v", err)
+		DB.Close()
+		DB = nil
+		return
+	}
+
 	fmt.Println("Database connected.")
 }
\ No newline at end of file
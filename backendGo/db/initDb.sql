@@ -17,3 +17,41 @@ CREATE TABLE IF NOT EXISTS walls (
     wall_geometry GEOMETRY(PolygonZ, 3857) NOT NULL
 );
 
+ALTER TABLE maps ADD COLUMN IF NOT EXISTS title VARCHAR(64);
+ALTER TABLE maps ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE maps ADD COLUMN IF NOT EXISTS map_config JSONB;
+ALTER TABLE maps ADD COLUMN IF NOT EXISTS grid JSONB;
+ALTER TABLE maps ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+CREATE UNIQUE INDEX IF NOT EXISTS maps_title_idx ON maps(title);
+
+CREATE TABLE IF NOT EXISTS buildings (
+    id SERIAL PRIMARY KEY,
+    map_id INT REFERENCES maps(id) ON DELETE CASCADE,
+    building_index INT NOT NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    height DOUBLE PRECISION NOT NULL,
+    min_height DOUBLE PRECISION NOT NULL DEFAULT 0,
+    height_source VARCHAR(64) NOT NULL DEFAULT '',
+    material VARCHAR(64) NOT NULL DEFAULT '',
+    roof_material VARCHAR(64) NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS buildings_map_id_idx ON buildings(map_id);
+
+ALTER TABLE walls ADD COLUMN IF NOT EXISTS building_id INT REFERENCES buildings(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS walls_map_id_idx ON walls(map_id);
+
+CREATE TABLE IF NOT EXISTS simulations (
+    id SERIAL PRIMARY KEY,
+    map_id INT REFERENCES maps(id) ON DELETE CASCADE,
+    config JSONB NOT NULL,
+    grid JSONB NOT NULL,
+    legend JSONB,
+    started_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NOT NULL,
+    size_x INT NOT NULL,
+    size_y INT NOT NULL,
+    size_z INT NOT NULL,
+    -- gzip compressed little endian float32 power map, z major then y then x
+    power_map BYTEA NOT NULL
+);
+CREATE INDEX IF NOT EXISTS simulations_map_id_idx ON simulations(map_id, finished_at DESC);
@@ -0,0 +1,137 @@
+package db
+
+import (
+	. "backendGo/types"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrUnavailable is returned when the server runs without a database.
+	ErrUnavailable = errors.New("database is not available")
+	ErrNotFound    = errors.New("not found")
+)
+
+// MapRecord is a map as stored in the maps table. Title is the name of the map's
+// directory in data, Width and Height the size of its grid in cells.
+type MapRecord struct {
+	Title       string          `json:"title"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Width       int             `json:"width"`
+	Height      int             `json:"height"`
+	Bounds      [2][2]float64   `json:"bounds"` // [[lonMin, latMin], [lonMax, latMax]]
+	MapConfig   json.RawMessage `json:"mapConfig"`
+	Grid        json.RawMessage `json:"grid"`
+}
+
+// point transforms a WGS 84 position to the web mercator geometries of the
+// maps and walls tables.
+func point(x, y string) string {
+	return fmt.Sprintf("ST_Transform(ST_SetSRID(ST_MakePoint(%s, %s), 4326), 3857)", x, y)
+}
+
+func pointZ(x, y, z string) string {
+	return fmt.Sprintf("ST_Transform(ST_SetSRID(ST_MakePoint(%s, %s, %s), 4326), 3857)", x, y, z)
+}
+
+// SaveMap stores the map and the walls of its buildings. A map with the same
+// title is replaced, together with its buildings and walls. It returns the id
+// of the map.
+func SaveMap(ctx context.Context, record MapRecord, buildings []Building) (int, error) {
+	if DB == nil {
+		return 0, ErrUnavailable
+	}
+	tx, err := DB.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var mapID int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO maps (title, name, description, width, height, bottom_left, top_right, map_config, grid)
+		VALUES ($1, $2, $3, $4, $5, `+point("$6::float8", "$7::float8")+`, `+point("$8::float8", "$9::float8")+`, $10, $11)
+		ON CONFLICT (title) DO UPDATE SET
+			name = EXCLUDED.name, description = EXCLUDED.description,
+			width = EXCLUDED.width, height = EXCLUDED.height,
+			bottom_left = EXCLUDED.bottom_left, top_right = EXCLUDED.top_right,
+			map_config = EXCLUDED.map_config, grid = EXCLUDED.grid
+		RETURNING id`,
+		record.Title, record.Name, record.Description, record.Width, record.Height,
+		record.Bounds[0][0], record.Bounds[0][1], record.Bounds[1][0], record.Bounds[1][1],
+		record.MapConfig, record.Grid,
+	).Scan(&mapID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save map: %w", err)
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM walls WHERE map_id = $1", mapID); err != nil {
+		return 0, fmt.Errorf("failed to delete walls: %w", err)
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM buildings WHERE map_id = $1", mapID); err != nil {
+		return 0, fmt.Errorf("failed to delete buildings: %w", err)
+	}
+
+	batch := &pgx.Batch{}
+	for i, building := range buildings {
+		batch.Queue(`
+			INSERT INTO buildings (map_id, building_index, name, height, min_height, height_source, material, roof_material)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING id`,
+			mapID, i, building.Name, building.Height, building.MinHeight,
+			building.HeightSource, building.Material, building.RoofMaterial)
+	}
+	buildingIDs := make([]int, len(buildings))
+	results := tx.SendBatch(ctx, batch)
+	for i := range buildings {
+		if err := results.QueryRow().Scan(&buildingIDs[i]); err != nil {
+			results.Close()
+			return 0, fmt.Errorf("failed to save building %d: %w", i, err)
+		}
+	}
+	if err := results.Close(); err != nil {
+		return 0, err
+	}
+
+	// a wall is the vertical rectangle between the bottom of its building and
+	// the top of the wall
+	batch = &pgx.Batch{}
+	for i, building := range buildings {
+		for _, wall := range building.Walls {
+			batch.Queue(`
+				INSERT INTO walls (map_id, building_id, bottom_left, top_right, wall_geometry)
+				VALUES ($1, $2, `+pointZ("$3::float8", "$4::float8", "$5::float8")+`, `+pointZ("$6::float8", "$7::float8", "$8::float8")+`,
+					ST_Transform(ST_SetSRID(ST_MakePolygon(ST_MakeLine(ARRAY[
+						ST_MakePoint($3, $4, $5), ST_MakePoint($6, $7, $5),
+						ST_MakePoint($6, $7, $8), ST_MakePoint($3, $4, $8),
+						ST_MakePoint($3, $4, $5)])), 4326), 3857))`,
+				mapID, buildingIDs[i],
+				wall.Start.X, wall.Start.Y, building.MinHeight,
+				wall.End.X, wall.End.Y, wall.End.Z)
+		}
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return 0, fmt.Errorf("failed to save walls: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return mapID, nil
+}
+
+// MapID returns the id of the map with title, or ErrNotFound.
+func MapID(ctx context.Context, title string) (int, error) {
+	if DB == nil {
+		return 0, ErrUnavailable
+	}
+	var id int
+	err := DB.QueryRow(ctx, "SELECT id FROM maps WHERE title = $1", title).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return id, err
+}
@@ -0,0 +1,20 @@
+package db
+
+import (
+	"context"
+	_ "embed"
+	"time"
+)
+
+//go:embed initDb.sql
+var schema string
+
+// migrate creates the tables of initDb.sql that are missing. The statements
+// are idempotent, so databases created by an older initDb.sql get the new
+// tables and columns too.
+func migrate(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	_, err := DB.Exec(ctx, schema)
+	return err
+}
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SimulationRecord is a finished ray launching run. Config is the request the
+// run was started with, Grid the grid of its power map and Legend its power
+// map legend. PowerMap is only filled in by GetSimulation.
+type SimulationRecord struct {
+	ID         int             `json:"id"`
+	MapTitle   string          `json:"mapTitle"`
+	Config     json.RawMessage `json:"config"`
+	Grid       json.RawMessage `json:"grid"`
+	Legend     json.RawMessage `json:"powerMapLegend"`
+	StartedAt  time.Time       `json:"startedAt"`
+	FinishedAt time.Time       `json:"finishedAt"`
+	SizeX      int             `json:"sizeX"`
+	SizeY      int             `json:"sizeY"`
+	SizeZ      int             `json:"sizeZ"`
+	PowerMap   [][][]float64   `json:"powerMap,omitempty"`
+}
+
+// compressPowerMap stores powerMap as gzip compressed little endian float32,
+// z major then y then x.
+func compressPowerMap(powerMap [][][]float64) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	row := []byte{}
+	for _, layer := range powerMap {
+		for _, values := range layer {
+			row = row[:0]
+			for _, value := range values {
+				row = binary.LittleEndian.AppendUint32(row, math.Float32bits(float32(value)))
+			}
+			if _, err := writer.Write(row); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressPowerMap(data []byte, sizeX, sizeY, sizeZ int) ([][][]float64, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	row := make([]byte, 4*sizeX)
+	powerMap := make([][][]float64, sizeZ)
+	for z := range powerMap {
+		powerMap[z] = make([][]float64, sizeY)
+		for y := range powerMap[z] {
+			if _, err := io.ReadFull(reader, row); err != nil {
+				return nil, fmt.Errorf("power map is truncated: %w", err)
+			}
+			powerMap[z][y] = make([]float64, sizeX)
+			for x := range powerMap[z][y] {
+				powerMap[z][y][x] = float64(math.Float32frombits(binary.LittleEndian.Uint32(row[4*x:])))
+			}
+		}
+	}
+	return powerMap, nil
+}
+
+// SaveSimulation stores record and its power map for the map with mapID and
+// returns the id of the simulation.
+func SaveSimulation(ctx context.Context, mapID int, record SimulationRecord) (int, error) {
+	if DB == nil {
+		return 0, ErrUnavailable
+	}
+	record.SizeZ = len(record.PowerMap)
+	if record.SizeZ > 0 {
+		record.SizeY = len(record.PowerMap[0])
+	}
+	if record.SizeY > 0 {
+		record.SizeX = len(record.PowerMap[0][0])
+	}
+	powerMap, err := compressPowerMap(record.PowerMap)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compress power map: %w", err)
+	}
+	var id int
+	err = DB.QueryRow(ctx, `
+		INSERT INTO simulations (map_id, config, grid, legend, started_at, finished_at, size_x, size_y, size_z, power_map)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id`,
+		mapID, record.Config, record.Grid, record.Legend,
+		record.StartedAt, record.FinishedAt, record.SizeX, record.SizeY, record.SizeZ, powerMap,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save simulation: %w", err)
+	}
+	return id, nil
+}
+
+const simulationColumns = `s.id, m.title, s.config, s.grid, s.legend,
+	s.started_at, s.finished_at, s.size_x, s.size_y, s.size_z`
+
+func scanSimulation(row pgx.Row, record *SimulationRecord, extra ...any) error {
+	return row.Scan(append([]any{
+		&record.ID, &record.MapTitle, &record.Config, &record.Grid, &record.Legend,
+		&record.StartedAt, &record.FinishedAt, &record.SizeX, &record.SizeY, &record.SizeZ,
+	}, extra...)...)
+}
+
+// ListSimulations returns the simulations of the map with mapTitle, or of all
+// maps when mapTitle is empty, newest first and without their power maps.
+func ListSimulations(ctx context.Context, mapTitle string) ([]SimulationRecord, error) {
+	if DB == nil {
+		return nil, ErrUnavailable
+	}
+	rows, err := DB.Query(ctx, `
+		SELECT `+simulationColumns+`
+		FROM simulations s JOIN maps m ON m.id = s.map_id
+		WHERE $1 = '' OR m.title = $1
+		ORDER BY s.finished_at DESC, s.id DESC`, mapTitle)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := []SimulationRecord{}
+	for rows.Next() {
+		var record SimulationRecord
+		if err := scanSimulation(rows, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// GetSimulation returns the simulation with id including its power map, or
+// ErrNotFound.
+func GetSimulation(ctx context.Context, id int) (SimulationRecord, error) {
+	var record SimulationRecord
+	if DB == nil {
+		return record, ErrUnavailable
+	}
+	var powerMap []byte
+	err := scanSimulation(DB.QueryRow(ctx, `
+		SELECT `+simulationColumns+`, s.power_map
+		FROM simulations s JOIN maps m ON m.id = s.map_id
+		WHERE s.id = $1`, id), &record, &powerMap)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return record, ErrNotFound
+	}
+	if err != nil {
+		return record, err
+	}
+	record.PowerMap, err = decompressPowerMap(powerMap, record.SizeX, record.SizeY, record.SizeZ)
+	return record, err
+}
@@ -0,0 +1,274 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusDone      Status = "done"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// RunFunc does the actual work of a job. It should return early with ctx.Err()
+// when ctx is cancelled and may call report with a progress value in [0, 1].
+type RunFunc func(ctx context.Context, report func(progress float64)) (any, error)
+
+type Job struct {
+	ID         string     `json:"id"`
+	MapTitle   string     `json:"mapTitle"`
+	Status     Status     `json:"status"`
+	Progress   float64    `json:"progress"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+
+	result any
+	cancel context.CancelFunc
+}
+
+func (job *Job) finished() bool {
+	return job.Status == StatusDone || job.Status == StatusFailed || job.Status == StatusCancelled
+}
+
+type Manager struct {
+	mu        sync.Mutex
+	jobs      map[string]*Job
+	resultTTL time.Duration
+	slots     chan struct{}
+}
+
+// NewManager creates a job manager that runs at most maxConcurrent jobs at a
+// time and forgets finished jobs (and their results) after resultTTL.
+func NewManager(resultTTL time.Duration, maxConcurrent int) *Manager {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	manager := &Manager{
+		jobs:      make(map[string]*Job),
+		resultTTL: resultTTL,
+		slots:     make(chan struct{}, maxConcurrent),
+	}
+	go manager.sweepExpired()
+	return manager
+}
+
+// NewManagerFromEnv reads JOB_RESULT_TTL (see ResultTTLFromEnv) and
+// JOB_MAX_CONCURRENT (default 2) from the environment.
+func NewManagerFromEnv() *Manager {
+	maxConcurrent := 2
+	if value := os.Getenv("JOB_MAX_CONCURRENT"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			log.Printf("Invalid JOB_MAX_CONCURRENT %q, using %d: %v", value, maxConcurrent, err)
+		} else {
+			maxConcurrent = parsed
+		}
+	}
+	return NewManager(ResultTTLFromEnv(), maxConcurrent)
+}
+
+// ResultTTLFromEnv reads JOB_RESULT_TTL (Go duration, default 30m) from the
+// environment.
+func ResultTTLFromEnv() time.Duration {
+	resultTTL := 30 * time.Minute
+	if value := os.Getenv("JOB_RESULT_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			log.Printf("Invalid JOB_RESULT_TTL %q, using %v: %v", value, resultTTL, err)
+		} else {
+			resultTTL = parsed
+		}
+	}
+	return resultTTL
+}
+
+func newJobID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Submit queues run and returns a snapshot of the new job.
+func (m *Manager) Submit(mapTitle string, run RunFunc) Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:        newJobID(),
+		MapTitle:  mapTitle,
+		Status:    StatusQueued,
+		CreatedAt: time.Now(),
+		cancel:    cancel,
+	}
+	m.mu.Lock()
+	m.jobs[job.ID] = job
+	snapshot := *job
+	m.mu.Unlock()
+
+	go m.execute(ctx, job, run)
+	return snapshot
+}
+
+// Done registers a job that is already finished with result, for results
+// that are known without running anything.
+func (m *Manager) Done(mapTitle string, result any) Job {
+	now := time.Now()
+	expires := now.Add(m.resultTTL)
+	job := &Job{
+		ID:         newJobID(),
+		MapTitle:   mapTitle,
+		Status:     StatusDone,
+		Progress:   1,
+		CreatedAt:  now,
+		StartedAt:  &now,
+		FinishedAt: &now,
+		ExpiresAt:  &expires,
+		result:     result,
+		cancel:     func() {},
+	}
+	m.mu.Lock()
+	m.jobs[job.ID] = job
+	snapshot := *job
+	m.mu.Unlock()
+	return snapshot
+}
+
+func (m *Manager) execute(ctx context.Context, job *Job, run RunFunc) {
+	select {
+	case m.slots <- struct{}{}:
+		defer func() { <-m.slots }()
+	case <-ctx.Done():
+		m.finish(job, nil, ctx.Err())
+		return
+	}
+
+	m.mu.Lock()
+	if job.finished() {
+		m.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	job.Status = StatusRunning
+	job.StartedAt = &now
+	m.mu.Unlock()
+
+	report := func(progress float64) {
+		m.mu.Lock()
+		job.Progress = progress
+		m.mu.Unlock()
+	}
+	defer func() {
+		// a panicking job must not take the server down with it
+		if r := recover(); r != nil {
+			log.Printf("Job %s panicked: %v", job.ID, r)
+			m.finish(job, nil, fmt.Errorf("job panicked: %v", r))
+		}
+	}()
+	result, err := run(ctx, report)
+	m.finish(job, result, err)
+}
+
+func (m *Manager) finish(job *Job, result any, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job.finished() {
+		return
+	}
+	now := time.Now()
+	expires := now.Add(m.resultTTL)
+	job.FinishedAt = &now
+	job.ExpiresAt = &expires
+	job.cancel()
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.Status = StatusCancelled
+	case err != nil:
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	default:
+		job.Status = StatusDone
+		job.Progress = 1
+		job.result = result
+	}
+}
+
+// Get returns a snapshot of the job with the given ID.
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Result returns the result of a finished job. The result is nil unless the
+// returned snapshot has StatusDone.
+func (m *Manager) Result(id string) (any, Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, Job{}, false
+	}
+	return job.result, *job, true
+}
+
+// Cancel stops a queued or running job. Cancelling a finished job only
+// removes it together with its result.
+func (m *Manager) Cancel(id string) (Job, bool) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return Job{}, false
+	}
+	if job.finished() {
+		delete(m.jobs, id)
+		snapshot := *job
+		m.mu.Unlock()
+		return snapshot, true
+	}
+	m.mu.Unlock()
+
+	m.finish(job, nil, context.Canceled)
+	return m.Get(id)
+}
+
+func (m *Manager) sweepExpired() {
+	interval := m.resultTTL / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		m.mu.Lock()
+		for id, job := range m.jobs {
+			if job.ExpiresAt != nil && now.After(*job.ExpiresAt) {
+				delete(m.jobs, id)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
@@ -11,6 +11,20 @@ func SetupRayCheckRoutes(router *gin.Engine) {
 	{
 		raycheckRouter.GET("/", controllers.GetMaps)
 		raycheckRouter.GET("/:mapTitle", controllers.GetMapById)
+		raycheckRouter.GET("/wallmatrix/:mapTitle", controllers.GetWallMatrix)
+		raycheckRouter.POST("/import", controllers.ImportMap)
+		raycheckRouter.GET("/import/jobs/:jobId", controllers.GetMapImportJob)
 		raycheckRouter.POST("/rayLaunch/:mapTitle", controllers.Create3DRayLaunching)
+		raycheckRouter.GET("/rayLaunch/jobs/:jobId", controllers.GetRayLaunchJob)
+		raycheckRouter.DELETE("/rayLaunch/jobs/:jobId", controllers.CancelRayLaunchJob)
+		raycheckRouter.GET("/rayLaunch/jobs/:jobId/result", controllers.GetRayLaunchJobResult)
+		raycheckRouter.GET("/rayLaunch/jobs/:jobId/geotiff/:z", controllers.GetRayLaunchJobGeoTIFF)
+		raycheckRouter.GET("/rayLaunch/jobs/:jobId/rayPaths", controllers.GetRayLaunchJobRayPaths)
+		raycheckRouter.POST("/rayLaunch/jobs/:jobId/statistics", controllers.GetRayLaunchJobStatistics)
+		raycheckRouter.GET("/rayLaunch/jobs/:jobId/heatmap/:z", controllers.GetRayLaunchJobHeatmap)
+		raycheckRouter.POST("/driveTest/:mapTitle", controllers.CompareDriveTest)
+		raycheckRouter.GET("/simulations", controllers.GetSimulations)
+		raycheckRouter.GET("/simulations/:simulationId", controllers.GetSimulation)
+		raycheckRouter.GET("/simulations/:simulationId/heatmap/:z", controllers.GetSimulationHeatmap)
 	}
 }
@@ -21,6 +21,14 @@ type TransmitterPos3D struct {
 type MapConfig struct {
 	LatMin, LatMax, LonMin, LonMax float64
 	Size, HeightMaxLevels          int
+	FloorHeight                    float64 // metres per building level, 0 means 3
+	// CellSize is the edge in metres of the cells of a metric (UTM) grid sized
+	// from the real extent of the map. 0 keeps the legacy Size×Size grid that
+	// is linear in lat and lon.
+	CellSize float64
+	// LevelHeight is the height in metres of one of the HeightMaxLevels
+	// levels, 0 means 1 m.
+	LevelHeight float64
 }
 
 type Point3D struct {
@@ -35,12 +43,52 @@ type Wall struct {
 }
 
 type Building struct {
-	Name   string  `json:"name"`
-	Height float64 `json:"height"`
-	Walls  []Wall  `json:"walls"`
+	Name         string  `json:"name"`
+	Height       float64 `json:"height"`
+	Material     string  `json:"material,omitempty"`
+	RoofMaterial string  `json:"roofMaterial,omitempty"`
+	MinHeight    float64 `json:"minHeight,omitempty"`    // bottom of raised parts, passages stay open below
+	HeightSource string  `json:"heightSource,omitempty"` // the tag Height was taken from
+	Walls        []Wall  `json:"walls"`
+}
+
+// WallInfo describes the wall with the same index in wallNormals3D.bin.
+type WallInfo struct {
+	Building int    `json:"building"`
+	Material string `json:"material"`
 }
 
 type SingleRay struct {
 	Azimuth   int `json:"azimuth" binding:"gte=0"`
 	Elevation int `json:"elevation" binding:"gte=0"`
 }
+
+// Antenna describes a sector antenna with the parametric pattern of 3GPP
+// TR 38.901 (table 7.3-1). Angles are given in degrees, gains and
+// attenuations in dB. Zero beamwidths and attenuations mean the 38.901
+// values (65°, 30 dB).
+type Antenna struct {
+	// Azimuth is the boresight direction, clockwise from north (map row 0).
+	Azimuth float64 `json:"azimuth" binding:"gte=0,lt=360"`
+	// MechanicalTilt tilts the whole antenna down, ElectricalTilt only the
+	// main lobe of the vertical pattern.
+	MechanicalTilt      float64 `json:"mechanicalTilt" binding:"gte=-90,lte=90"`
+	ElectricalTilt      float64 `json:"electricalTilt" binding:"gte=-90,lte=90"`
+	HorizontalBeamwidth float64 `json:"horizontalBeamwidth" binding:"omitempty,gt=0,lte=360"`
+	VerticalBeamwidth   float64 `json:"verticalBeamwidth" binding:"omitempty,gt=0,lte=180"`
+	// FrontToBackRatio is the maximum attenuation A_m, SideLobeLevel the
+	// maximum attenuation of the vertical pattern SLA_V.
+	FrontToBackRatio float64 `json:"frontToBackRatio" binding:"omitempty,gt=0,lte=100"`
+	SideLobeLevel    float64 `json:"sideLobeLevel" binding:"omitempty,gt=0,lte=100"`
+	// MaxGain is the main lobe gain in dBi, nil means 8 dBi.
+	MaxGain *float64 `json:"maxGain" binding:"omitempty,gte=-20,lte=40"`
+}
+
+// Measurement is a single drive-test sample. Lat, Lon and Power are pointers
+// so that a missing coordinate or power is rejected while 0 is accepted.
+type Measurement struct {
+	Lat    *float64 `json:"lat" binding:"required,gte=-90,lte=90"`
+	Lon    *float64 `json:"lon" binding:"required,gte=-180,lte=180"`
+	Height float64  `json:"height" binding:"gte=0"`                    // metres above ground
+	Power  *float64 `json:"power" binding:"required,gte=-200,lte=100"` // dbm
+}
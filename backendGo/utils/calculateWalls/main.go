@@ -15,10 +15,11 @@ import (
 
 func main() {
 	genHeatmaps := flag.Bool("gen-heatmaps", false, "Generate heatmaps to /imgs/raw folder")
+	osmPath := flag.String("osm", "", "Read buildings inside the map bounds from a local .osm or .osm.pbf extract instead of rawBuildings.json")
 	flag.Parse()
 
 	if flag.NArg() < 1 {
-		fmt.Println("Usage: go run main.go [-gen-heatmaps] ../../data/<MAP_NAME>")
+		fmt.Println("Usage: go run main.go [-gen-heatmaps] [-osm city.osm.pbf] ../../data/<MAP_NAME>")
 		os.Exit(1)
 	}
 	mapFolderPath := flag.Arg(0)
@@ -37,7 +38,14 @@ func main() {
 		log.Fatalf("Error parsing JSON %v", err)
 	}
 
-	calculations.CalculateWallsMatrix3D(mapFolderPath, config)
+	if *osmPath != "" {
+		err = calculations.CalculateWallsMatrix3DFromOSM(mapFolderPath, *osmPath, config, nil)
+	} else {
+		err = calculations.CalculateWallsMatrix3D(mapFolderPath, config, nil)
+	}
+	if err != nil {
+		log.Fatalf("Error calculating walls matrix %v", err)
+	}
 
 	wallsMatrixPath := filepath.Join(mapFolderPath, "wallsMatrix3D_floor.bin")
 	wallNormalsPath := filepath.Join(mapFolderPath, "wallNormals3D.bin")
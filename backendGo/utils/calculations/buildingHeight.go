@@ -0,0 +1,150 @@
+package calculations
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultFloorHeight is the height of one level in metres when the map
+// config does not set FloorHeight.
+const DefaultFloorHeight = 3.0
+
+// defaultLevels is assumed for buildings without any height or level tag.
+const defaultLevels = 3
+
+// Height sources recorded in Building.HeightSource.
+const (
+	HeightSourceHeight         = "height"
+	HeightSourceBuildingHeight = "building:height"
+	HeightSourceLevels         = "building:levels"
+	HeightSourceDefault        = "default"
+)
+
+var (
+	feetAndInchesPattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)'\s*(?:(\d+(?:\.\d+)?)")?$`)
+	lengthPattern        = regexp.MustCompile(`^(-?\d+(?:\.\d+)?)\s*(m|meters?|metres?|ft|feet|foot)?$`)
+)
+
+// parseLength parses an OSM length value in metres. Plain numbers are metres,
+// "12 m", "40 ft" and "12'6\"" are accepted as well.
+func parseLength(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		text := strings.ToLower(strings.TrimSpace(strings.ReplaceAll(v, ",", ".")))
+		if match := feetAndInchesPattern.FindStringSubmatch(text); match != nil {
+			feet, _ := strconv.ParseFloat(match[1], 64)
+			inches := 0.0
+			if match[2] != "" {
+				inches, _ = strconv.ParseFloat(match[2], 64)
+			}
+			return feet*0.3048 + inches*0.0254, true
+		}
+		match := lengthPattern.FindStringSubmatch(text)
+		if match == nil {
+			return 0, false
+		}
+		length, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			return 0, false
+		}
+		switch match[2] {
+		case "ft", "feet", "foot":
+			length *= 0.3048
+		}
+		return length, true
+	}
+	return 0, false
+}
+
+// lengthTag returns the first tag of keys that holds a valid positive length.
+// A 0, which OSM often carries as a placeholder, falls through to the next key.
+func lengthTag(properties map[string]any, keys ...string) (float64, string, bool) {
+	for _, key := range keys {
+		if value, ok := properties[key]; ok {
+			if length, ok := parseLength(value); ok && length > 0 && !math.IsInf(length, 0) {
+				return length, key, true
+			}
+		}
+	}
+	return 0, "", false
+}
+
+// levelsTag returns the number of levels in key, fractional counts allowed.
+func levelsTag(properties map[string]any, key string) (float64, bool) {
+	value, ok := properties[key]
+	if !ok {
+		return 0, false
+	}
+	var levels float64
+	switch v := value.(type) {
+	case float64:
+		levels = v
+	case int:
+		levels = float64(v)
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(strings.ReplaceAll(v, ",", ".")), 64)
+		if err != nil {
+			return 0, false
+		}
+		levels = parsed
+	default:
+		return 0, false
+	}
+	return levels, levels >= 0
+}
+
+type buildingHeight struct {
+	height    float64 // top of the building, roof included
+	minHeight float64 // bottom of the building above the ground
+	source    string
+}
+
+// resolveBuildingHeight follows the Simple 3D Buildings tag precedence:
+// height (which includes the roof), then building:height, then
+// building:levels times floorHeight plus the roof given by roof:height or
+// roof:levels. Without any of these defaultLevels are assumed. The bottom of
+// the building is min_height, or building:min_level times floorHeight.
+func resolveBuildingHeight(properties map[string]any, floorHeight float64) buildingHeight {
+	if floorHeight <= 0 {
+		floorHeight = DefaultFloorHeight
+	}
+	var result buildingHeight
+	if height, key, ok := lengthTag(properties, "height", "building:height"); ok {
+		result.height = height
+		result.source = HeightSourceHeight
+		if key == "building:height" {
+			result.source = HeightSourceBuildingHeight
+		}
+	} else {
+		levels, ok := levelsTag(properties, "building:levels")
+		result.source = HeightSourceLevels
+		if !ok {
+			levels = defaultLevels
+			result.source = HeightSourceDefault
+		}
+		roofHeight, _, ok := lengthTag(properties, "roof:height")
+		if !ok {
+			roofLevels, _ := levelsTag(properties, "roof:levels")
+			roofHeight = roofLevels * floorHeight
+		}
+		result.height = levels*floorHeight + roofHeight
+	}
+
+	if minHeight, _, ok := lengthTag(properties, "min_height"); ok {
+		result.minHeight = minHeight
+	} else if minLevel, ok := levelsTag(properties, "building:min_level"); ok {
+		result.minHeight = minLevel * floorHeight
+	}
+	if result.minHeight >= result.height {
+		fmt.Printf("Ignoring minimum height %.1f m above the building height %.1f m\n", result.minHeight, result.height)
+		result.minHeight = 0
+	}
+	return result
+}
@@ -0,0 +1,34 @@
+package calculations
+
+import (
+	"math"
+	"testing"
+)
+
+func TestResolveBuildingHeight(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		properties map[string]any
+		height     float64
+		minHeight  float64
+		source     string
+	}{
+		{"height", map[string]any{"height": "18", "building:height": "12", "building:levels": "2"}, 18, 0, HeightSourceHeight},
+		{"height in feet", map[string]any{"height": "30 ft"}, 9.144, 0, HeightSourceHeight},
+		{"zero height", map[string]any{"height": "0", "building:height": "12"}, 12, 0, HeightSourceBuildingHeight},
+		{"zero heights", map[string]any{"height": 0.0, "building:height": "0", "building:levels": "4"}, 12, 0, HeightSourceLevels},
+		{"levels and roof", map[string]any{"building:levels": "4", "roof:height": "2.5"}, 14.5, 0, HeightSourceLevels},
+		{"levels and roof levels", map[string]any{"building:levels": "2", "roof:levels": "1"}, 9, 0, HeightSourceLevels},
+		{"invalid height", map[string]any{"height": "tall", "building:levels": "1"}, 3, 0, HeightSourceLevels},
+		{"no tags", map[string]any{"building": "yes"}, 9, 0, HeightSourceDefault},
+		{"min height", map[string]any{"height": "20", "min_height": "6"}, 20, 6, HeightSourceHeight},
+		{"min level", map[string]any{"building:levels": "5", "building:min_level": "2"}, 15, 6, HeightSourceLevels},
+		{"min height above the top", map[string]any{"height": "5", "min_height": "8"}, 5, 0, HeightSourceHeight},
+	} {
+		got := resolveBuildingHeight(test.properties, DefaultFloorHeight)
+		if math.Abs(got.height-test.height) > 1e-9 || math.Abs(got.minHeight-test.minHeight) > 1e-9 || got.source != test.source {
+			t.Errorf("%s: got height %g, min height %g from %s, want %g, %g from %s",
+				test.name, got.height, got.minHeight, got.source, test.height, test.minHeight, test.source)
+		}
+	}
+}
@@ -2,15 +2,17 @@ package calculations
 
 import (
 	. "backendGo/types"
+	"bufio"
 	"encoding/binary"
 	"encoding/gob"
 	"encoding/json" // Potrzebne do Unmarshal
 	"fmt"
 	"io"
-	"log" // Używasz log.Fatalf
+	"log"
 	"math"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // GeoJSON structures with flexible property types
@@ -25,24 +27,104 @@ type Feature struct {
 	Geometry   Geometry       `json:"geometry"`
 }
 
+// Geometry keeps the coordinates undecoded since their nesting depends on
+// the type, see Polygons.
 type Geometry struct {
-	Type        string        `json:"type"`
-	Coordinates [][][]float64 `json:"coordinates"`
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
 }
 
-func calculateWalls(folderPath string) string {
+// Polygons returns the polygons of a Polygon or MultiPolygon geometry, each
+// one an outer ring followed by its inner rings. Other geometry types have no
+// polygons.
+func (g Geometry) Polygons() ([][][][]float64, error) {
+	switch g.Type {
+	case "Polygon":
+		var polygon [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &polygon); err != nil {
+			return nil, fmt.Errorf("invalid Polygon coordinates: %w", err)
+		}
+		return [][][][]float64{polygon}, nil
+	case "MultiPolygon":
+		var polygons [][][][]float64
+		if err := json.Unmarshal(g.Coordinates, &polygons); err != nil {
+			return nil, fmt.Errorf("invalid MultiPolygon coordinates: %w", err)
+		}
+		return polygons, nil
+	}
+	return nil, nil
+}
+
+func polygonGeometry(polygons [][][][]float64) Geometry {
+	geometry := Geometry{Type: "MultiPolygon"}
+	var coordinates any = polygons
+	if len(polygons) == 1 {
+		geometry.Type = "Polygon"
+		coordinates = polygons[0]
+	}
+	geometry.Coordinates, _ = json.Marshal(coordinates)
+	return geometry
+}
+
+// signedArea is positive for counter clockwise rings.
+func signedArea(ring [][]float64) float64 {
+	area := 0.0
+	for i := range ring {
+		next := ring[(i+1)%len(ring)]
+		area += ring[i][0]*next[1] - next[0]*ring[i][1]
+	}
+	return area / 2
+}
+
+// ringWalls returns one wall per edge of ring. Inner rings are walked in the
+// opposite direction to the outer ring, so that the normals of all walls of a
+// polygon point to the same side of the building material.
+func ringWalls(ring [][]float64, height float64, reverse bool) []Wall {
+	if reverse {
+		reversed := make([][]float64, len(ring))
+		for i, point := range ring {
+			reversed[len(ring)-1-i] = point
+		}
+		ring = reversed
+	}
+	walls := make([]Wall, 0, len(ring))
+	for i := 0; i < len(ring); i++ {
+		current, next := ring[i], ring[(i+1)%len(ring)]
+		walls = append(walls, Wall{
+			Start: Point3D{X: current[0], Y: current[1], Z: height},
+			End:   Point3D{X: next[0], Y: next[1], Z: height},
+		})
+	}
+	return walls
+}
+
+func calculateWalls(folderPath string, floorHeight float64) ([]Building, error) {
 	rawPath := filepath.Join(folderPath, "rawBuildings.json")
 	data, err := os.ReadFile(rawPath)
 	if err != nil {
-		log.Fatalf("Failed to read data file: %v", err)
+		return nil, fmt.Errorf("failed to read data file: %w", err)
 	}
 	var featureCollection FeatureCollection
 	err = json.Unmarshal(data, &featureCollection)
 	if err != nil {
-		log.Fatalf("Error parsing JSON: %v", err)
+		return nil, fmt.Errorf("error parsing JSON: %w", err)
+	}
+	buildings, err := buildingsFromFeatures(featureCollection.Features, floorHeight)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveBuildings(folderPath, buildings); err != nil {
+		return nil, err
 	}
+	return buildings, nil
+}
+
+// buildingsFromFeatures turns GeoJSON features with OSM tags as properties
+// into buildings with one wall per edge of every ring, courtyards included.
+// Heights are resolved from the tags with floorHeight metres per level.
+func buildingsFromFeatures(features []Feature, floorHeight float64) ([]Building, error) {
 	var buildings []Building
-	for i, feature := range featureCollection.Features {
+	for i, feature := range features {
 		buildingIndex := i + 1
 
 		buildingName := fmt.Sprintf("Building %d", buildingIndex)
@@ -50,67 +132,72 @@ func calculateWalls(folderPath string) string {
 			buildingName = fmt.Sprintf("%v", name)
 		}
 
-		var heightInLevels float64 = 3
-		if levels, ok := feature.Properties["building:levels"]; ok {
-			switch v := levels.(type) {
-			case float64:
-				heightInLevels = v
-			case int:
-				heightInLevels = float64(v)
-			case string:
-				fmt.Sscanf(v, "%f", &heightInLevels)
-			}
-		}
-
-		heightInMeters := heightInLevels * 3.0
+		height := resolveBuildingHeight(feature.Properties, floorHeight)
+		heightInMeters := height.height
 		buildingOutput := Building{
-			Name:   buildingName,
-			Height: heightInMeters,
-			Walls:  []Wall{},
+			Name:         buildingName,
+			Height:       heightInMeters,
+			Material:     materialTag(feature.Properties, "building:facade:material", "building:material"),
+			RoofMaterial: materialTag(feature.Properties, "roof:material"),
+			MinHeight:    height.minHeight,
+			HeightSource: height.source,
+			Walls:        []Wall{},
 		}
-		if feature.Geometry.Type == "Polygon" {
-			if len(feature.Geometry.Coordinates) > 0 {
-				ring := feature.Geometry.Coordinates[0]
-				for i := 0; i < len(ring); i++ {
-
-					current := Point3D{
-						X: ring[i][0],
-						Y: ring[i][1],
-						Z: heightInMeters,
-					}
-					nextIdx := (i + 1) % len(ring)
-					next := Point3D{
-						X: ring[nextIdx][0],
-						Y: ring[nextIdx][1],
-						Z: heightInMeters,
-					}
-					wall := Wall{Start: current, End: next}
-					buildingOutput.Walls = append(buildingOutput.Walls, wall)
+		polygons, err := feature.Geometry.Polygons()
+		if err != nil {
+			return nil, fmt.Errorf("feature %d: %w", buildingIndex, err)
+		}
+		if polygons == nil {
+			fmt.Printf("Skipping walls of feature %d with geometry %s\n", buildingIndex, feature.Geometry.Type)
+		}
+		for _, polygon := range polygons {
+			for ringIndex, ring := range polygon {
+				if len(ring) < 2 {
+					continue
 				}
+				reverse := ringIndex > 0 && (signedArea(ring) > 0) == (signedArea(polygon[0]) > 0)
+				buildingOutput.Walls = append(buildingOutput.Walls, ringWalls(ring, heightInMeters, reverse)...)
 			}
 		}
 
 		buildings = append(buildings, buildingOutput)
 	}
+	return buildings, nil
+}
+
+func saveBuildings(folderPath string, buildings []Building) error {
 	outputJSON, err := json.MarshalIndent(buildings, "", "  ")
 	if err != nil {
-		log.Fatalf("Error creating JSON: %v", err)
+		return fmt.Errorf("error creating JSON: %w", err)
 	}
 	outputFilePath := filepath.Join(folderPath, "buildings.json")
 	err = os.WriteFile(outputFilePath, outputJSON, 0644)
 	if err != nil {
-		log.Fatalf("Error writing file %s: %v", outputFilePath, err)
+		return fmt.Errorf("error writing file %s: %w", outputFilePath, err)
 	}
 	fmt.Printf("Saved all buildings to %s\n", outputFilePath)
 	fmt.Println("Processing complete")
-	return outputFilePath
+	return nil
+}
+
+// materialTag returns the first non empty value of the given OSM tags,
+// normalised to lower case.
+func materialTag(properties map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := properties[key]; ok {
+			material := strings.ToLower(strings.TrimSpace(fmt.Sprintf("%v", value)))
+			if material != "" {
+				return material
+			}
+		}
+	}
+	return ""
 }
-func geoToMatrixIndex(lat, lon, latMin, latMax, lonMin, lonMax float64, size int) (int, int) {
-	y := (lat - latMin) / (latMax - latMin) * float64(size-1)
-	x := (lon - lonMin) / (lonMax - lonMin) * float64(size-1)
-	i := int(math.Round(x))
-	j := int(math.Round(y))
-	return i, j
+
+// GeoToProcessedMatrixIndex returns the (x, y) index of the processed matrix
+// (and of the power map) for a point, with row 0 at LatMax like the frontend.
+func GeoToProcessedMatrixIndex(lat, lon float64, mapConfig MapConfig) (int, int) {
+	return NewMapGrid(mapConfig).ProcessedIndex(lat, lon)
 }
 
 func AngleBetweenNormals(a, b Normal3D) float64 {
@@ -130,7 +217,7 @@ func AngleBetweenNormals(a, b Normal3D) float64 {
 	return theta * 180 / math.Pi
 }
 
-func drawLine(matrix [][][]int16, wallNormals []Normal3D, x1, y1, z1, x2, y2, z2, heightLevels, wallIndex, sizeX, sizeY int) {
+func drawLine(matrix [][][]int16, wallNormals []Normal3D, x1, y1, z1, x2, y2, z2, zMin, heightLevels, wallIndex, sizeX, sizeY int) {
 	dx := x2 - x1
 	dy := y2 - y1
 	minCornerAngle := 40.0
@@ -147,7 +234,7 @@ func drawLine(matrix [][][]int16, wallNormals []Normal3D, x1, y1, z1, x2, y2, z2
 		}
 		for y := y1; y <= y2; y++ {
 			if y >= 0 && y < sizeY {
-				for z := 0; z <= z1; z++ {
+				for z := zMin; z <= z1; z++ {
 					if matrix[z][y][x1] >= 1000 && matrix[z][y][x1] < 5000 && matrix[z][y][x1] != int16(1000+wallIndex) {
 						wallA := wallNormals[int(matrix[z][y][x1])-1000]
 						wallB := wallNormals[wallIndex]
@@ -169,7 +256,7 @@ func drawLine(matrix [][][]int16, wallNormals []Normal3D, x1, y1, z1, x2, y2, z2
 		}
 		for x := x1; x <= x2; x++ {
 			if x >= 0 && x < sizeX {
-				for z := 0; z <= z1; z++ {
+				for z := zMin; z <= z1; z++ {
 					if matrix[z][y1][x] >= 1000 && matrix[z][y1][x] < 5000 && matrix[z][y1][x] != int16(1000+wallIndex) {
 						wallA := wallNormals[int(matrix[z][y1][x])-1000]
 						wallB := wallNormals[wallIndex]
@@ -196,7 +283,7 @@ func drawLine(matrix [][][]int16, wallNormals []Normal3D, x1, y1, z1, x2, y2, z2
 			yIdx := y
 			if prevXIdx < xIdx && prevYIdx < yIdx || prevXIdx < xIdx && prevYIdx > yIdx {
 				if yIdx >= 0 && yIdx < sizeY && prevXIdx >= 0 && prevXIdx < sizeX {
-					for z := 0; z <= z1; z++ {
+					for z := zMin; z <= z1; z++ {
 						if matrix[z][yIdx][prevXIdx] >= 1000 && matrix[z][yIdx][prevXIdx] < 5000 && matrix[z][yIdx][prevXIdx] != int16(1000+wallIndex) {
 							wallA := wallNormals[int(matrix[z][yIdx][prevXIdx])-1000]
 							wallB := wallNormals[wallIndex]
@@ -214,7 +301,7 @@ func drawLine(matrix [][][]int16, wallNormals []Normal3D, x1, y1, z1, x2, y2, z2
 			}
 			if prevXIdx > xIdx && prevYIdx < yIdx || prevXIdx > xIdx && prevYIdx > yIdx {
 				if xIdx >= 0 && xIdx < sizeX && prevYIdx >= 0 && prevYIdx < sizeY {
-					for z := 0; z <= z1; z++ {
+					for z := zMin; z <= z1; z++ {
 						if matrix[z][prevYIdx][xIdx] >= 1000 && matrix[z][prevYIdx][xIdx] < 5000 && matrix[z][prevYIdx][xIdx] != int16(1000+wallIndex) {
 							wallA := wallNormals[int(matrix[z][prevYIdx][xIdx])-1000]
 							wallB := wallNormals[wallIndex]
@@ -231,12 +318,11 @@ func drawLine(matrix [][][]int16, wallNormals []Normal3D, x1, y1, z1, x2, y2, z2
 				}
 			} // walls continuity
 			if xIdx >= 0 && xIdx < sizeX && yIdx >= 0 && yIdx < sizeY {
-				for z := 0; z <= z1; z++ {
+				for z := zMin; z <= z1; z++ {
 					if matrix[z][yIdx][xIdx] >= 1000 && matrix[z][yIdx][xIdx] < 5000 && matrix[z][yIdx][xIdx] != int16(1000+wallIndex) {
 						wallA := wallNormals[int(matrix[z][yIdx][xIdx])-1000]
 						wallB := wallNormals[wallIndex]
 						angle := AngleBetweenNormals(wallA, wallB)
-						println(angle)
 						if angle > minCornerAngle && angle < maxCornerAngle {
 							matrix[z][yIdx][xIdx] = 10000
 						} else {
@@ -265,39 +351,45 @@ func calculateNormal3D(x1, y1, z1, x2, y2, z2 int) Normal3D {
 	return Normal3D{Nx: nx, Ny: ny, Nz: 0}
 }
 
-func generateBuildingMatrix(buildings []Building, latMin, latMax, lonMin, lonMax float64, size, heightLevels int) ([][][]int16, []Normal3D) {
+// generateBuildingMatrix draws the walls of buildings into a matrix of
+// heightLevels levels, wall i labelled WallMapNumber+i. It fails if there are
+// more walls than labels below RoofMapNumber.
+func generateBuildingMatrix(buildings []Building, grid MapGrid, heightLevels int) ([][][]int16, []Normal3D, []WallInfo, error) {
 	matrix := make([][][]int16, heightLevels)
 	wallNormals := []Normal3D{}
+	wallInfo := []WallInfo{}
 	for z := range matrix {
-		matrix[z] = make([][]int16, size)
+		matrix[z] = make([][]int16, grid.SizeY)
 		for y := range matrix[z] {
-			matrix[z][y] = make([]int16, size)
+			matrix[z][y] = make([]int16, grid.SizeX)
 			for x := range matrix[z][y] {
 				matrix[z][y][x] = -160.0
 			}
 		}
 	}
 	wallsMapIndex := 0
-	wallHeights := make(map[int]int)
-	for _, building := range buildings {
+	for buildingIndex, building := range buildings {
+		zMin := grid.HeightToLevel(building.MinHeight)
 		for _, wall := range building.Walls {
-			i1, j1 := geoToMatrixIndex(wall.Start.Y, wall.Start.X, latMin, latMax, lonMin, lonMax, size)
-			i2, j2 := geoToMatrixIndex(wall.End.Y, wall.End.X, latMin, latMax, lonMin, lonMax, size)
-			z1 := int(math.Round(wall.Start.Z))
-			z2 := int(math.Round(wall.End.Z))
+			i1, j1 := grid.geoToIndex(wall.Start.Y, wall.Start.X)
+			i2, j2 := grid.geoToIndex(wall.End.Y, wall.End.X)
+			z1 := grid.HeightToLevel(wall.Start.Z)
+			z2 := grid.HeightToLevel(wall.End.Z)
 			normal := calculateNormal3D(i1, j1, z1, i2, j2, z2)
 			if normal.Nx == 0 && normal.Ny == 0 {
 				continue
 			}
+			if wallsMapIndex >= RoofMapNumber-WallMapNumber {
+				return nil, nil, nil, fmt.Errorf("the map has more than %d walls, choose smaller bounds", RoofMapNumber-WallMapNumber)
+			}
 			wallNormals = append(wallNormals, normal)
-			drawLine(matrix, wallNormals, i1, j1, z1, i2, j2, z2, heightLevels, wallsMapIndex, size, size)
-			wallHeights[wallsMapIndex] = z1
+			wallInfo = append(wallInfo, WallInfo{Building: buildingIndex, Material: building.Material})
+			drawLine(matrix, wallNormals, i1, j1, z1, i2, j2, z2, zMin, heightLevels, wallsMapIndex, grid.SizeX, grid.SizeY)
 			wallsMapIndex++
 		}
 	}
-	fmt.Printf("walls: %v \n", wallsMapIndex)
-	fmt.Printf("normals: %v \n", len(wallNormals))
-	return matrix, wallNormals
+	log.Printf("Drew %d walls", wallsMapIndex)
+	return matrix, wallNormals, wallInfo, nil
 }
 
 func saveBinary(data interface{}, folderPath, filename string) error {
@@ -330,7 +422,7 @@ func saveRawBinary3D(matrix [][][]int16, folderPath, filename string) error {
 	}
 	defer file.Close()
 
-	// Make sure we write using LittleEndian (Python expects this format)
+	// Raw matrices are stored as little endian int16 (the frontend reads them as Int16Array)
 	byteOrder := binary.LittleEndian
 
 	fmt.Printf("Saving raw 3D data to: %s\n", path)
@@ -340,7 +432,7 @@ func saveRawBinary3D(matrix [][][]int16, folderPath, filename string) error {
 				err := binary.Write(file, byteOrder, val)
 				if err != nil {
 					// Provide a more detailed error
-					return fmt.Errorf("error writing value %f to %s: %w", val, path, err)
+					return fmt.Errorf("error writing value %d to %s: %w", val, path, err)
 				}
 			}
 		}
@@ -349,8 +441,8 @@ func saveRawBinary3D(matrix [][][]int16, folderPath, filename string) error {
 	return nil
 }
 
-// loadRawBinary3D reads a raw binary file (created by Python) into [][][]float32
-func loadRawBinary3D(path string, z, y, x int) ([][][]int16, error) {
+// LoadRawBinary3D reads a raw little endian int16 binary file into [][][]int16
+func LoadRawBinary3D(path string, z, y, x int) ([][][]int16, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("error opening input file '%s': %w", path, err)
@@ -377,6 +469,7 @@ func loadRawBinary3D(path string, z, y, x int) ([][][]int16, error) {
 	}
 
 	byteOrder := binary.LittleEndian
+	reader := bufio.NewReader(file)
 
 	fmt.Printf("Reading 3D data from: %s (%dx%dx%d)\n", path, z, y, x)
 	expectedReads := z * y * x
@@ -385,7 +478,7 @@ func loadRawBinary3D(path string, z, y, x int) ([][][]int16, error) {
 		for yi := 0; yi < y; yi++ {
 			for xi := 0; xi < x; xi++ {
 				var value int16
-				err := binary.Read(file, byteOrder, &value)
+				err := binary.Read(reader, byteOrder, &value)
 				if err != nil {
 					if err == io.EOF {
 						return nil, fmt.Errorf("unexpected end of file (EOF) in '%s' after reading %d out of %d expected numbers. File is too short.", path, reads, expectedReads)
@@ -401,53 +494,84 @@ func loadRawBinary3D(path string, z, y, x int) ([][][]int16, error) {
 	return data, nil
 }
 
-func CalculateWallsMatrix3D(folderPath string, mapConfig MapConfig) {
-	fmt.Println("Starting CalculateWallsMatrix3D...")
-	buildingsFilePath := calculateWalls(folderPath)
-	data, err := os.ReadFile(buildingsFilePath)
-	if err != nil {
-		log.Fatalf("Failed to read building data file '%s': %v", buildingsFilePath, err)
+// CalculateWallsMatrix3D turns rawBuildings.json in folderPath into the
+// matrices used by the ray launching. progress, if not nil, is called with
+// the fraction of the work done.
+func CalculateWallsMatrix3D(folderPath string, mapConfig MapConfig, progress func(float64)) error {
+	report := func(done float64) {
+		if progress != nil {
+			progress(done)
+		}
 	}
-	var buildings []Building
-	err = json.Unmarshal(data, &buildings)
+	fmt.Println("Starting CalculateWallsMatrix3D...")
+	buildings, err := calculateWalls(folderPath, mapConfig.FloorHeight)
 	if err != nil {
-		log.Fatalf("Błąd parsowania JSON z '%s': %v", buildingsFilePath, err)
+		return err
 	}
+	report(0.1)
+	return generateMatrices(folderPath, buildings, mapConfig, report)
+}
 
-	matrix, wallNormals := generateBuildingMatrix(buildings, mapConfig.LatMin, mapConfig.LatMax, mapConfig.LonMin, mapConfig.LonMax, mapConfig.Size, mapConfig.HeightMaxLevels)
+// generateMatrices writes the wall, normal and building matrices of
+// buildings to folderPath. report is called with the fraction of the work
+// done, starting after 0.1.
+func generateMatrices(folderPath string, buildings []Building, mapConfig MapConfig, report func(float64)) error {
 
-	saveBinary(matrix, folderPath, "wallsMatrix3D.bin")
-	saveBinary(wallNormals, folderPath, "wallNormals3D.bin")
+	grid := NewMapGrid(mapConfig)
+	if err := grid.Validate(); err != nil {
+		return err
+	}
+	fmt.Printf("Map grid: %s %dx%dx%d cells of %.2fx%.2fx%.2f m\n", grid.Projection, grid.SizeX, grid.SizeY, grid.SizeZ, grid.MetresPerCellX, grid.MetresPerCellY, grid.MetresPerLevel)
+	matrix, wallNormals, wallInfo, err := generateBuildingMatrix(buildings, grid, mapConfig.HeightMaxLevels)
+	if err != nil {
+		return err
+	}
+	report(0.4)
 
-	// Save a raw binary version for Python processing
+	err = saveBinary(matrix, folderPath, "wallsMatrix3D.bin")
+	if err != nil {
+		return fmt.Errorf("failed to save wallsMatrix3D.bin: %w", err)
+	}
+	err = saveBinary(wallNormals, folderPath, "wallNormals3D.bin")
+	if err != nil {
+		return fmt.Errorf("failed to save wallNormals3D.bin: %w", err)
+	}
+	err = saveBinary(wallInfo, folderPath, "wallInfo3D.bin")
+	if err != nil {
+		return fmt.Errorf("failed to save wallInfo3D.bin: %w", err)
+	}
 
-	rawInputForPythonFilename := "wallsMatrix3D_raw.bin"
-	err = saveRawBinary3D(matrix, folderPath, rawInputForPythonFilename)
+	rawFilename := "wallsMatrix3D_raw.bin"
+	err = saveRawBinary3D(matrix, folderPath, rawFilename)
 	if err != nil {
-		fmt.Printf("BŁĄD KRYTYCZNY: Nie udało się zapisać surowych danych 3D dla Pythona: %v\n", err)
-		return
+		return fmt.Errorf("failed to save raw 3D data '%s': %w", rawFilename, err)
 	}
+	report(0.5)
 
-	// Load the processed binary (returned from Python)
-	processedRawOutputFromPythonFilename := "wallsMatrix3D_processed.bin"
-	processedRawOutputFromPythonPath := filepath.Join(folderPath, processedRawOutputFromPythonFilename)
-	processedMatrix3D, err := loadRawBinary3D(processedRawOutputFromPythonPath, mapConfig.HeightMaxLevels, mapConfig.Size, mapConfig.Size)
+	processedMatrix3D := ProcessWallsMatrix3D(matrix, buildings, mapConfig)
+	buildingMap := GenerateBuildingMap(matrix, buildings, wallInfo, mapConfig)
+	report(0.8)
+	err = saveBinary(buildingMap, folderPath, "buildingMap2D.bin")
 	if err != nil {
-		fmt.Printf("\nCRITICAL ERROR: Failed to read processed data from '%s': %v\n", processedRawOutputFromPythonPath, err)
-		return
+		fmt.Printf("\nERROR: Failed to save buildingMap2D.bin: %v\n", err)
+	}
+	processedRawFilename := "wallsMatrix3D_processed.bin"
+	err = saveRawBinary3D(processedMatrix3D, folderPath, processedRawFilename)
+	if err != nil {
+		return fmt.Errorf("failed to save processed 3D data '%s': %w", processedRawFilename, err)
 	}
 
 	finalGobFilename := "wallsMatrix3D_floor.bin"
 	err = saveBinary(processedMatrix3D, folderPath, finalGobFilename)
 	if err != nil {
-		fmt.Printf("\nCRITICAL ERROR: Failed to save final Gob file '%s': %v\n", finalGobFilename, err)
-		fmt.Printf("\nCRITICAL ERROR: Failed to save final Gob file '%s': %v\n", "wallsMatrix3D.bin", err)
-		return
+		return fmt.Errorf("failed to save final Gob file '%s': %w", finalGobFilename, err)
 	}
 	err = saveBinary(wallNormals, folderPath, "wallNormals3D.bin")
 	if err != nil {
-		fmt.Printf("\nERROR: Failed to save wallNormals3D.bin: %v\n", err)
+		return fmt.Errorf("failed to save wallNormals3D.bin: %w", err)
 	}
+	report(1)
+	return nil
 }
 
 func ConvertInt16MatrixToFloat64(matrixInt [][][]int16) [][][]float64 {
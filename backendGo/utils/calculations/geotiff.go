@@ -0,0 +1,188 @@
+package calculations
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// GeoTIFFNoData is written for building voxels (walls, roofs, corners and
+// unreached building interiors) and announced in the GDAL_NODATA tag.
+const GeoTIFFNoData = -9999.0
+
+const (
+	tiffShort  = 3
+	tiffLong   = 4
+	tiffASCII  = 2
+	tiffDouble = 12
+)
+
+type tiffEntry struct {
+	tag   uint16
+	kind  uint16
+	count uint32
+	data  []byte
+}
+
+func tiffShorts(tag uint16, values ...uint16) tiffEntry {
+	data := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint16(data[2*i:], v)
+	}
+	return tiffEntry{tag: tag, kind: tiffShort, count: uint32(len(values)), data: data}
+}
+
+func tiffLongs(tag uint16, values ...uint32) tiffEntry {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(data[4*i:], v)
+	}
+	return tiffEntry{tag: tag, kind: tiffLong, count: uint32(len(values)), data: data}
+}
+
+func tiffDoubles(tag uint16, values ...float64) tiffEntry {
+	data := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(data[8*i:], math.Float64bits(v))
+	}
+	return tiffEntry{tag: tag, kind: tiffDouble, count: uint32(len(values)), data: data}
+}
+
+func tiffString(tag uint16, value string) tiffEntry {
+	data := append([]byte(value), 0)
+	return tiffEntry{tag: tag, kind: tiffASCII, count: uint32(len(data)), data: data}
+}
+
+// WriteGeoTIFF writes one z-slice of a power map as a single band float32
+// GeoTIFF, in EPSG:4326 for legacy linear grids and in the UTM zone of the
+// grid (EPSG:326xx/327xx) for metric grids. Row 0 of the slice is the
+// northern edge of the map, like in the processed matrix. Pixels are centred
+// on the matrix grid points, so the raster extends half a pixel beyond the
+// map bounds. Values at or above wallMapNumber are geometry labels and become
+// GeoTIFFNoData. grid is the grid of the power map, NewMapGrid of the map or
+// a resampled grid.
+func WriteGeoTIFF(w io.Writer, slice [][]float64, grid MapGrid, wallMapNumber int) error {
+	height := len(slice)
+	if height == 0 || len(slice[0]) == 0 {
+		return fmt.Errorf("empty power map slice")
+	}
+	width := len(slice[0])
+	if err := grid.Validate(); err != nil {
+		return err
+	}
+	if width != grid.SizeX || height != grid.SizeY {
+		return fmt.Errorf("slice of %dx%d values does not match the map grid of %dx%d cells", width, height, grid.SizeX, grid.SizeY)
+	}
+
+	var scaleX, scaleY, left, top float64
+	var geoKeys []uint16
+	if grid.Projection == ProjectionUTM {
+		scaleX, scaleY = grid.MetresPerCellX, grid.MetresPerCellY
+		left = grid.OriginEasting - scaleX/2
+		top = grid.OriginNorthing + float64(grid.SizeY-1)*scaleY + scaleY/2
+		epsg := uint16(32600 + grid.UTMZone)
+		if grid.South {
+			epsg = uint16(32700 + grid.UTMZone)
+		}
+		geoKeys = []uint16{
+			1, 1, 0, 4, // header: version 1.1.0, 4 keys
+			1024, 0, 1, 1, // GTModelTypeGeoKey: projected
+			1025, 0, 1, 1, // GTRasterTypeGeoKey: PixelIsArea
+			3072, 0, 1, epsg, // ProjectedCSTypeGeoKey: WGS 84 / UTM
+			3076, 0, 1, 9001, // ProjLinearUnitsGeoKey: metre
+		}
+	} else {
+		mapConfig := grid.mapConfig
+		scaleX = (mapConfig.LonMax - mapConfig.LonMin) / float64(mapConfig.Size-1) * float64(grid.stride())
+		scaleY = (mapConfig.LatMax - mapConfig.LatMin) / float64(mapConfig.Size-1) * float64(grid.stride())
+		top = mapConfig.LatMax
+		if grid.stride() > 1 {
+			// the northern row of a resampled grid may lie beyond the map
+			top = mapConfig.LatMin + float64(grid.SizeY-1)*scaleY
+		}
+		left, top = mapConfig.LonMin-scaleX/2, top+scaleY/2
+		geoKeys = []uint16{
+			1, 1, 0, 4, // header: version 1.1.0, 4 keys
+			1024, 0, 1, 2, // GTModelTypeGeoKey: geographic
+			1025, 0, 1, 1, // GTRasterTypeGeoKey: PixelIsArea
+			2048, 0, 1, 4326, // GeographicTypeGeoKey: WGS 84
+			2054, 0, 1, 9102, // GeogAngularUnitsGeoKey: degree
+		}
+	}
+
+	pixels := make([]byte, 4*width*height)
+	for y, row := range slice {
+		if len(row) != width {
+			return fmt.Errorf("row %d has %d values, expected %d", y, len(row), width)
+		}
+		for x, value := range row {
+			if value >= float64(wallMapNumber) {
+				value = GeoTIFFNoData
+			}
+			binary.LittleEndian.PutUint32(pixels[4*(y*width+x):], math.Float32bits(float32(value)))
+		}
+	}
+
+	const imageOffset = 8
+	entries := []tiffEntry{
+		tiffLongs(256, uint32(width)),         // ImageWidth
+		tiffLongs(257, uint32(height)),        // ImageLength
+		tiffShorts(258, 32),                   // BitsPerSample
+		tiffShorts(259, 1),                    // Compression: none
+		tiffShorts(262, 1),                    // PhotometricInterpretation: BlackIsZero
+		tiffLongs(273, imageOffset),           // StripOffsets
+		tiffShorts(277, 1),                    // SamplesPerPixel
+		tiffLongs(278, uint32(height)),        // RowsPerStrip
+		tiffLongs(279, uint32(len(pixels))),   // StripByteCounts
+		tiffShorts(284, 1),                    // PlanarConfiguration: chunky
+		tiffShorts(339, 3),                    // SampleFormat: IEEE float
+		tiffDoubles(33550, scaleX, scaleY, 0), // ModelPixelScaleTag
+		// ModelTiepointTag: upper left corner of pixel (0, 0)
+		tiffDoubles(33922, 0, 0, 0, left, top, 0),
+		tiffShorts(34735, geoKeys...), // GeoKeyDirectoryTag
+		// GDAL_NODATA
+		tiffString(42113, strconv.FormatFloat(GeoTIFFNoData, 'f', -1, 64)),
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	ifdOffset := imageOffset + len(pixels)
+	ifdOffset += ifdOffset % 2
+	extraOffset := ifdOffset + 2 + 12*len(entries) + 4
+
+	var out bytes.Buffer
+	out.WriteString("II")
+	binary.Write(&out, binary.LittleEndian, uint16(42))
+	binary.Write(&out, binary.LittleEndian, uint32(ifdOffset))
+	out.Write(pixels)
+	for out.Len() < ifdOffset {
+		out.WriteByte(0)
+	}
+
+	var extra bytes.Buffer
+	binary.Write(&out, binary.LittleEndian, uint16(len(entries)))
+	for _, entry := range entries {
+		binary.Write(&out, binary.LittleEndian, entry.tag)
+		binary.Write(&out, binary.LittleEndian, entry.kind)
+		binary.Write(&out, binary.LittleEndian, entry.count)
+		if len(entry.data) <= 4 {
+			value := make([]byte, 4)
+			copy(value, entry.data)
+			out.Write(value)
+			continue
+		}
+		binary.Write(&out, binary.LittleEndian, uint32(extraOffset+extra.Len()))
+		extra.Write(entry.data)
+		if extra.Len()%2 == 1 {
+			extra.WriteByte(0)
+		}
+	}
+	binary.Write(&out, binary.LittleEndian, uint32(0)) // no further IFDs
+	out.Write(extra.Bytes())
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
@@ -0,0 +1,208 @@
+package calculations
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Colormaps of HeatmapOptions.
+const (
+	ColormapJet      = "jet"
+	ColormapViridis  = "viridis"
+	ColormapDiscrete = "discrete"
+)
+
+// Building styles of HeatmapOptions.
+const (
+	BuildingsOpaque      = "opaque"
+	BuildingsTransparent = "transparent"
+	BuildingsHidden      = "hidden"
+)
+
+// HeatmapOptions configure RenderHeatmap. Powers are mapped linearly from Min
+// to Max onto the colormap and clamped outside of it. Discrete cuts the range
+// into Bands bands of the jet colormap. Scale is the number of pixels per
+// cell.
+type HeatmapOptions struct {
+	Colormap  string
+	Min, Max  float64
+	Bands     int
+	Buildings string
+	Colorbar  bool
+	Scale     int
+}
+
+// transparentBuildingAlpha is the opacity of buildings drawn with
+// BuildingsTransparent.
+const transparentBuildingAlpha = 96
+
+// viridis are ten evenly spaced samples of the viridis colormap.
+var viridis = []color.RGBA{
+	{68, 1, 84, 255}, {72, 40, 120, 255}, {62, 74, 137, 255}, {49, 104, 142, 255}, {38, 130, 142, 255},
+	{31, 158, 137, 255}, {53, 183, 121, 255}, {109, 205, 89, 255}, {180, 222, 44, 255}, {253, 231, 37, 255},
+}
+
+// buildingColor returns the color GenerateHeatmap uses for a geometry label,
+// false if value is a power.
+func buildingColor(value float64) (color.RGBA, bool) {
+	switch {
+	case value == 20000:
+		return color.RGBA{255, 255, 0, 255}, true
+	case value == 10001:
+		return color.RGBA{128, 0, 128, 255}, true
+	case value == 10000:
+		return color.RGBA{255, 0, 255, 255}, true
+	case value == 5000:
+		return color.RGBA{192, 192, 192, 255}, true
+	case value >= 1000:
+		return color.RGBA{0, 0, 0, 255}, true
+	}
+	return color.RGBA{}, false
+}
+
+func jetColor(value float64) color.RGBA {
+	channel := func(centre float64) uint8 {
+		return uint8(255 * math.Max(0, math.Min(1, 1.5-math.Abs(4*value-centre))))
+	}
+	return color.RGBA{channel(3), channel(2), channel(1), 255}
+}
+
+func viridisColor(value float64) color.RGBA {
+	position := value * float64(len(viridis)-1)
+	lower := int(math.Floor(position))
+	if lower >= len(viridis)-1 {
+		return viridis[len(viridis)-1]
+	}
+	t := position - float64(lower)
+	mix := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a) + t*(float64(b)-float64(a))))
+	}
+	a, b := viridis[lower], viridis[lower+1]
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 255}
+}
+
+// powerColor returns the color of power.
+func (options HeatmapOptions) powerColor(power float64) color.RGBA {
+	value := (power - options.Min) / (options.Max - options.Min)
+	value = math.Max(0, math.Min(1, value))
+	switch options.Colormap {
+	case ColormapViridis:
+		return viridisColor(value)
+	case ColormapDiscrete:
+		bands := options.bands()
+		band := math.Min(math.Floor(value*float64(bands)), float64(bands-1))
+		return jetColor((band + 0.5) / float64(bands))
+	}
+	return jetColor(value)
+}
+
+func (options HeatmapOptions) bands() int {
+	if options.Bands < 2 {
+		return 8
+	}
+	return options.Bands
+}
+
+// RenderHeatmap draws a level of a power map with row 0 at the top. Voxels no
+// ray reached (-160) are transparent, buildings are drawn in the colors of
+// GenerateHeatmap, half transparent or not at all, as options.Buildings says.
+// With options.Colorbar a colorbar with labelled ticks is added to the right.
+func RenderHeatmap(powerMap [][]float64, options HeatmapOptions) *image.RGBA {
+	scale := max(options.Scale, 1)
+	height := len(powerMap) * scale
+	width := 0
+	if len(powerMap) > 0 {
+		width = len(powerMap[0]) * scale
+	}
+
+	mapImage := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, row := range powerMap {
+		for x, value := range row {
+			c, isBuilding := buildingColor(value)
+			switch {
+			case isBuilding && options.Buildings == BuildingsHidden:
+				continue
+			case isBuilding && options.Buildings == BuildingsTransparent:
+				c = color.RGBA{
+					uint8(uint16(c.R) * transparentBuildingAlpha / 255),
+					uint8(uint16(c.G) * transparentBuildingAlpha / 255),
+					uint8(uint16(c.B) * transparentBuildingAlpha / 255),
+					transparentBuildingAlpha,
+				}
+			case isBuilding:
+			case value == -160:
+				continue
+			default:
+				c = options.powerColor(value)
+			}
+			draw.Draw(mapImage, image.Rect(x*scale, y*scale, (x+1)*scale, (y+1)*scale), image.NewUniform(c), image.Point{}, draw.Src)
+		}
+	}
+	if !options.Colorbar {
+		return mapImage
+	}
+
+	bar := options.renderColorbar(max(height, 160))
+	img := image.NewRGBA(image.Rect(0, 0, width+bar.Bounds().Dx(), max(height, bar.Bounds().Dy())))
+	draw.Draw(img, mapImage.Bounds(), mapImage, image.Point{}, draw.Src)
+	draw.Draw(img, bar.Bounds().Add(image.Pt(width, 0)), bar, image.Point{}, draw.Src)
+	return img
+}
+
+// colorbarTickStep returns the smallest of the usual steps that leaves at most
+// ticks ticks over the range of options.
+func (options HeatmapOptions) colorbarTickStep(ticks int) float64 {
+	span := options.Max - options.Min
+	for _, step := range []float64{0.1, 0.2, 0.5, 1, 2, 5, 10, 20, 25, 50, 100} {
+		if span/step <= float64(ticks) {
+			return step
+		}
+	}
+	return math.Ceil(span / float64(ticks))
+}
+
+// renderColorbar draws the colormap from Max at the top to Min at the bottom
+// on white, with a tick and a label every colorbarTickStep.
+func (options HeatmapOptions) renderColorbar(height int) *image.RGBA {
+	const margin, barWidth, tickLength = 8, 16, 4
+	face := basicfont.Face7x13
+	lineHeight := face.Metrics().Height.Ceil()
+
+	step := options.colorbarTickStep(max((height-2*margin)/(2*lineHeight), 2))
+	var ticks []float64
+	for tick := math.Ceil(options.Min/step) * step; tick <= options.Max+step*1e-9; tick += step {
+		ticks = append(ticks, tick)
+	}
+	labelWidth := 0
+	labels := make([]string, len(ticks))
+	for i, tick := range ticks {
+		labels[i] = strconv.FormatFloat(math.Round(tick/step)*step, 'f', -1, 64)
+		labelWidth = max(labelWidth, font.MeasureString(face, labels[i]).Ceil())
+	}
+
+	width := margin + barWidth + tickLength + 2 + labelWidth + margin
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	top, bottom := margin, height-margin
+	for y := top; y < bottom; y++ {
+		power := options.Max - (float64(y-top)+0.5)/float64(bottom-top)*(options.Max-options.Min)
+		draw.Draw(img, image.Rect(margin, y, margin+barWidth, y+1), image.NewUniform(options.powerColor(power)), image.Point{}, draw.Src)
+	}
+
+	drawer := font.Drawer{Dst: img, Src: image.Black, Face: face}
+	for i, tick := range ticks {
+		y := top + int(math.Round((options.Max-tick)/(options.Max-options.Min)*float64(bottom-top-1)))
+		draw.Draw(img, image.Rect(margin+barWidth, y, margin+barWidth+tickLength, y+1), image.Black, image.Point{}, draw.Src)
+		baseline := min(max(y+face.Metrics().Ascent.Ceil()/2, lineHeight), height-2)
+		drawer.Dot = fixed.P(margin+barWidth+tickLength+2, baseline)
+		drawer.DrawString(labels[i])
+	}
+	return img
+}
@@ -0,0 +1,274 @@
+package calculations
+
+import (
+	. "backendGo/types"
+	"fmt"
+	"math"
+)
+
+// Map grid projections.
+const (
+	// ProjectionUTM places the cells CellSize metres apart in the UTM zone of
+	// the map centre.
+	ProjectionUTM = "utm"
+	// ProjectionLinear is the legacy Size×Size grid, linear in lat and lon.
+	// Its cells are not square in metres.
+	ProjectionLinear = "linear"
+)
+
+// maxGridCells limits the horizontal size of a metric grid, every cell is
+// stored for every height level. maxGridVoxels limits the whole matrix.
+const (
+	maxGridCells  = 4000 * 4000
+	maxGridVoxels = maxGridCells * 30
+)
+
+// MapGrid maps geographic coordinates to the cells of a map's matrices. Cell
+// (0, 0) lies on the south west corner of the map, the processed matrix and
+// the power map flip the rows (see GeoToProcessedMatrixIndex).
+type MapGrid struct {
+	Projection     string  `json:"projection"`
+	SizeX          int     `json:"sizeX"`
+	SizeY          int     `json:"sizeY"`
+	MetresPerCellX float64 `json:"metresPerCellX"`
+	MetresPerCellY float64 `json:"metresPerCellY"`
+	UTMZone        int     `json:"utmZone,omitempty"`
+	South          bool    `json:"south,omitempty"`
+	// OriginEasting and OriginNorthing are the UTM coordinates of the centre
+	// of cell (0, 0).
+	OriginEasting  float64 `json:"originEasting,omitempty"`
+	OriginNorthing float64 `json:"originNorthing,omitempty"`
+	// SizeZ height levels of MetresPerLevel each, level 0 is the ground.
+	SizeZ          int     `json:"sizeZ"`
+	MetresPerLevel float64 `json:"metresPerLevel"`
+	// Stride is set on resampled linear grids, which keep every Stride-th
+	// point of the Size×Size grid of the map.
+	Stride int `json:"stride,omitempty"`
+
+	mapConfig MapConfig
+}
+
+// NewMapGrid returns the grid of mapConfig: a UTM grid of CellSize metres when
+// CellSize is set, the legacy linear Size×Size grid otherwise.
+func NewMapGrid(mapConfig MapConfig) MapGrid {
+	grid := MapGrid{SizeZ: mapConfig.HeightMaxLevels, MetresPerLevel: mapConfig.LevelHeight, mapConfig: mapConfig}
+	if grid.MetresPerLevel <= 0 {
+		grid.MetresPerLevel = 1
+	}
+	if mapConfig.CellSize <= 0 {
+		grid.Projection = ProjectionLinear
+		grid.SizeX, grid.SizeY = mapConfig.Size, mapConfig.Size
+		if mapConfig.Size > 1 {
+			latCenter := (mapConfig.LatMin + mapConfig.LatMax) / 2
+			latMetres, lonMetres := metresPerDegree(latCenter)
+			grid.MetresPerCellX = (mapConfig.LonMax - mapConfig.LonMin) * lonMetres / float64(mapConfig.Size-1)
+			grid.MetresPerCellY = (mapConfig.LatMax - mapConfig.LatMin) * latMetres / float64(mapConfig.Size-1)
+		}
+		return grid
+	}
+
+	grid.Projection = ProjectionUTM
+	grid.MetresPerCellX, grid.MetresPerCellY = mapConfig.CellSize, mapConfig.CellSize
+	lonCenter := (mapConfig.LonMin + mapConfig.LonMax) / 2
+	grid.UTMZone = int(math.Floor((lonCenter+180)/6)) + 1
+	grid.South = (mapConfig.LatMin+mapConfig.LatMax)/2 < 0
+
+	minE, minN := math.Inf(1), math.Inf(1)
+	maxE, maxN := math.Inf(-1), math.Inf(-1)
+	for _, lat := range []float64{mapConfig.LatMin, mapConfig.LatMax} {
+		for _, lon := range []float64{mapConfig.LonMin, lonCenter, mapConfig.LonMax} {
+			e, n := toUTM(lat, lon, grid.UTMZone, grid.South)
+			minE, maxE = math.Min(minE, e), math.Max(maxE, e)
+			minN, maxN = math.Min(minN, n), math.Max(maxN, n)
+		}
+	}
+	grid.OriginEasting, grid.OriginNorthing = minE, minN
+	grid.SizeX = int(math.Ceil((maxE-minE)/mapConfig.CellSize)) + 1
+	grid.SizeY = int(math.Ceil((maxN-minN)/mapConfig.CellSize)) + 1
+	return grid
+}
+
+// Validate rejects grids that are empty or too large to allocate.
+func (g MapGrid) Validate() error {
+	if g.SizeX < 2 || g.SizeY < 2 {
+		return fmt.Errorf("map grid of %dx%d cells is too small", g.SizeX, g.SizeY)
+	}
+	if g.SizeX*g.SizeY > maxGridCells {
+		return fmt.Errorf("map grid of %dx%d cells is too large, increase the cell size", g.SizeX, g.SizeY)
+	}
+	if g.SizeX*g.SizeY*g.SizeZ > maxGridVoxels {
+		return fmt.Errorf("map grid of %dx%dx%d voxels is too large, increase the cell size or the level height", g.SizeX, g.SizeY, g.SizeZ)
+	}
+	return nil
+}
+
+// CellSize is the metres per cell coarser runs are requested in. Legacy linear
+// grids count as 1 m cells, the ray launching uses their real size per axis
+// (MetresPerCellX and MetresPerCellY).
+func (g MapGrid) CellSize() float64 {
+	if g.Projection == ProjectionUTM {
+		return g.MetresPerCellX
+	}
+	return float64(g.stride())
+}
+
+func (g MapGrid) stride() int {
+	if g.Stride < 1 {
+		return 1
+	}
+	return g.Stride
+}
+
+// HeightToLevel returns the level of a height in metres above the ground.
+func (g MapGrid) HeightToLevel(height float64) int {
+	return int(math.Round(height / g.MetresPerLevel))
+}
+
+// Resample returns the grid made of every stride-th cell and every
+// levelStride-th level of g. Cell (0, 0, 0) stays in place and the last cells
+// may extend beyond the map.
+func (g MapGrid) Resample(stride, levelStride int) MapGrid {
+	r := g
+	r.SizeX = resampledSize(g.SizeX, stride)
+	r.SizeY = resampledSize(g.SizeY, stride)
+	r.SizeZ = resampledSize(g.SizeZ, levelStride)
+	r.MetresPerCellX *= float64(stride)
+	r.MetresPerCellY *= float64(stride)
+	r.MetresPerLevel *= float64(levelStride)
+	if g.Projection == ProjectionLinear && g.stride()*stride > 1 {
+		r.Stride = g.stride() * stride
+	}
+	return r
+}
+
+// resampledSize is the number of points k*stride that cover size points.
+func resampledSize(size, stride int) int {
+	if size < 1 {
+		return size
+	}
+	return (size+stride-2)/stride + 1
+}
+
+// GeoToCell returns the fractional cell coordinates of a point, with y
+// growing to the north.
+func (g MapGrid) GeoToCell(lat, lon float64) (float64, float64) {
+	if g.Projection == ProjectionUTM {
+		e, n := toUTM(lat, lon, g.UTMZone, g.South)
+		return (e - g.OriginEasting) / g.MetresPerCellX, (n - g.OriginNorthing) / g.MetresPerCellY
+	}
+	c := g.mapConfig
+	x := (lon - c.LonMin) / (c.LonMax - c.LonMin) * float64(c.Size-1)
+	y := (lat - c.LatMin) / (c.LatMax - c.LatMin) * float64(c.Size-1)
+	if stride := g.stride(); stride > 1 {
+		x, y = x/float64(stride), y/float64(stride)
+	}
+	return x, y
+}
+
+// CellToGeo is the inverse of GeoToCell.
+func (g MapGrid) CellToGeo(x, y float64) (float64, float64) {
+	if g.Projection == ProjectionUTM {
+		return fromUTM(g.OriginEasting+x*g.MetresPerCellX, g.OriginNorthing+y*g.MetresPerCellY, g.UTMZone, g.South)
+	}
+	c := g.mapConfig
+	if stride := g.stride(); stride > 1 {
+		x, y = x*float64(stride), y*float64(stride)
+	}
+	lat := c.LatMin + y/float64(c.Size-1)*(c.LatMax-c.LatMin)
+	lon := c.LonMin + x/float64(c.Size-1)*(c.LonMax-c.LonMin)
+	return lat, lon
+}
+
+// geoToIndex returns the cell of a point in the raw (south up) matrices.
+func (g MapGrid) geoToIndex(lat, lon float64) (int, int) {
+	x, y := g.GeoToCell(lat, lon)
+	return int(math.Round(x)), int(math.Round(y))
+}
+
+// ProcessedIndex returns the (x, y) index of the processed matrix (and of the
+// power map) for a point, with row 0 at the northern edge.
+func (g MapGrid) ProcessedIndex(lat, lon float64) (int, int) {
+	i, j := g.geoToIndex(lat, lon)
+	return i, g.SizeY - 1 - j
+}
+
+// ProcessedToGeo is the inverse of ProcessedIndex for fractional indices, it
+// returns the latitude and longitude of point (x, y) of the processed matrix.
+func (g MapGrid) ProcessedToGeo(x, y float64) (float64, float64) {
+	return g.CellToGeo(x, float64(g.SizeY-1)-y)
+}
+
+// metresPerDegree returns the length of one degree of latitude and of
+// longitude at lat on the WGS84 ellipsoid.
+func metresPerDegree(lat float64) (float64, float64) {
+	phi := lat * math.Pi / 180
+	latMetres := 111132.92 - 559.82*math.Cos(2*phi) + 1.175*math.Cos(4*phi) - 0.0023*math.Cos(6*phi)
+	lonMetres := 111412.84*math.Cos(phi) - 93.5*math.Cos(3*phi) + 0.118*math.Cos(5*phi)
+	return latMetres, lonMetres
+}
+
+// WGS84 ellipsoid and UTM constants.
+const (
+	wgs84A  = 6378137.0
+	wgs84F  = 1 / 298.257223563
+	utmK0   = 0.9996
+	utmE0   = 500000.0
+	utmNSth = 10000000.0
+)
+
+// toUTM projects a point to easting and northing in the given UTM zone
+// (Snyder, Map Projections - A Working Manual, pp. 61).
+func toUTM(lat, lon float64, zone int, south bool) (float64, float64) {
+	e2 := wgs84F * (2 - wgs84F)
+	ep2 := e2 / (1 - e2)
+	phi := lat * math.Pi / 180
+	lambda0 := float64((zone-1)*6-180+3) * math.Pi / 180
+	lambda := lon * math.Pi / 180
+
+	sinPhi, cosPhi := math.Sin(phi), math.Cos(phi)
+	n := wgs84A / math.Sqrt(1-e2*sinPhi*sinPhi)
+	t := math.Tan(phi) * math.Tan(phi)
+	c := ep2 * cosPhi * cosPhi
+	a := (lambda - lambda0) * cosPhi
+	m := wgs84A * ((1-e2/4-3*e2*e2/64-5*e2*e2*e2/256)*phi -
+		(3*e2/8+3*e2*e2/32+45*e2*e2*e2/1024)*math.Sin(2*phi) +
+		(15*e2*e2/256+45*e2*e2*e2/1024)*math.Sin(4*phi) -
+		(35*e2*e2*e2/3072)*math.Sin(6*phi))
+
+	easting := utmK0*n*(a+(1-t+c)*math.Pow(a, 3)/6+(5-18*t+t*t+72*c-58*ep2)*math.Pow(a, 5)/120) + utmE0
+	northing := utmK0 * (m + n*math.Tan(phi)*(a*a/2+(5-t+9*c+4*c*c)*math.Pow(a, 4)/24+
+		(61-58*t+t*t+600*c-330*ep2)*math.Pow(a, 6)/720))
+	if south {
+		northing += utmNSth
+	}
+	return easting, northing
+}
+
+// fromUTM is the inverse of toUTM.
+func fromUTM(easting, northing float64, zone int, south bool) (float64, float64) {
+	e2 := wgs84F * (2 - wgs84F)
+	ep2 := e2 / (1 - e2)
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+	if south {
+		northing -= utmNSth
+	}
+	m := northing / utmK0
+	mu := m / (wgs84A * (1 - e2/4 - 3*e2*e2/64 - 5*e2*e2*e2/256))
+	phi1 := mu + (3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+		(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+		(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+		(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+
+	sinPhi1, cosPhi1 := math.Sin(phi1), math.Cos(phi1)
+	c1 := ep2 * cosPhi1 * cosPhi1
+	t1 := math.Tan(phi1) * math.Tan(phi1)
+	n1 := wgs84A / math.Sqrt(1-e2*sinPhi1*sinPhi1)
+	r1 := wgs84A * (1 - e2) / math.Pow(1-e2*sinPhi1*sinPhi1, 1.5)
+	d := (easting - utmE0) / (n1 * utmK0)
+
+	phi := phi1 - (n1*math.Tan(phi1)/r1)*(d*d/2-(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+		(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+	lambda := (d - (1+2*t1+c1)*math.Pow(d, 3)/6 + (5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120) / cosPhi1
+	lambda0 := float64((zone-1)*6-180+3) * math.Pi / 180
+	return phi * 180 / math.Pi, (lambda0 + lambda) * 180 / math.Pi
+}
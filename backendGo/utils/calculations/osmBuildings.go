@@ -0,0 +1,80 @@
+package calculations
+
+import (
+	. "backendGo/types"
+	"backendGo/utils/osm"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// osmFeatures converts OSM footprints to the GeoJSON features an Overpass
+// export would contain, tags as properties and the way or relation as "@id".
+func osmFeatures(footprints []osm.Footprint) []Feature {
+	features := make([]Feature, 0, len(footprints))
+	for _, footprint := range footprints {
+		properties := make(map[string]any, len(footprint.Tags)+1)
+		for key, value := range footprint.Tags {
+			properties[key] = value
+		}
+		properties["@id"] = footprint.ID
+		polygons := make([][][][]float64, len(footprint.Polygons))
+		for i, polygon := range footprint.Polygons {
+			polygons[i] = make([][][]float64, len(polygon))
+			for j, ring := range polygon {
+				polygons[i][j] = make([][]float64, len(ring))
+				for k, point := range ring {
+					polygons[i][j][k] = []float64{point[0], point[1]}
+				}
+			}
+		}
+		features = append(features, Feature{
+			Type:       "Feature",
+			Properties: properties,
+			Geometry:   polygonGeometry(polygons),
+		})
+	}
+	return features
+}
+
+// CalculateWallsMatrix3DFromOSM is CalculateWallsMatrix3D for a local .osm or
+// .osm.pbf extract instead of rawBuildings.json. The buildings inside the
+// bounds of mapConfig are taken from the extract, rawBuildings.json is written
+// from them for the frontend.
+func CalculateWallsMatrix3DFromOSM(folderPath, osmPath string, mapConfig MapConfig, progress func(float64)) error {
+	report := func(done float64) {
+		if progress != nil {
+			progress(done)
+		}
+	}
+	fmt.Printf("Reading buildings from %s...\n", osmPath)
+	bbox := osm.BBox{MinLat: mapConfig.LatMin, MaxLat: mapConfig.LatMax, MinLon: mapConfig.LonMin, MaxLon: mapConfig.LonMax}
+	footprints, err := osm.LoadBuildingFootprints(osmPath, bbox)
+	if err != nil {
+		return err
+	}
+	if len(footprints) == 0 {
+		return fmt.Errorf("no buildings found in %s inside the map bounds", osmPath)
+	}
+	fmt.Printf("Found %d buildings\n", len(footprints))
+
+	features := osmFeatures(footprints)
+	rawJSON, err := json.Marshal(FeatureCollection{Type: "FeatureCollection", Features: features})
+	if err != nil {
+		return fmt.Errorf("error creating JSON: %w", err)
+	}
+	rawPath := filepath.Join(folderPath, "rawBuildings.json")
+	if err := os.WriteFile(rawPath, rawJSON, 0644); err != nil {
+		return fmt.Errorf("error writing file %s: %w", rawPath, err)
+	}
+	buildings, err := buildingsFromFeatures(features, mapConfig.FloorHeight)
+	if err != nil {
+		return err
+	}
+	if err := saveBuildings(folderPath, buildings); err != nil {
+		return err
+	}
+	report(0.1)
+	return generateMatrices(folderPath, buildings, mapConfig, report)
+}
@@ -0,0 +1,202 @@
+package calculations
+
+import (
+	. "backendGo/types"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Voxel labels shared with RayLaunching3DConfig (see controllers.Create3DRayLaunching).
+const (
+	EmptyMapNumber            = -160
+	WallMapNumber             = 1000
+	RoofMapNumber             = 5000
+	CornerMapNumber           = 10000
+	RoofCornerMapNumber       = 10001
+	BuildingInteriorMapNumber = 20000
+)
+
+// footprint is a building outline in matrix coordinates together with the
+// levels of its bottom and roof. The roof level may lie above the top of the
+// matrix, in which case the building has no roof voxels. The outline is the set of wall
+// edges of all rings of the building, so courtyards (inner rings) and the
+// parts of a MultiPolygon need no special treatment.
+type footprint struct {
+	building  int
+	edges     [][2]Point
+	baseLevel int
+	roofLevel int
+}
+
+func buildingFootprints(buildings []Building, grid MapGrid) []footprint {
+	footprints := make([]footprint, 0, len(buildings))
+	for buildingIndex, building := range buildings {
+		if len(building.Walls) < 3 {
+			continue
+		}
+		edges := make([][2]Point, 0, len(building.Walls))
+		for _, wall := range building.Walls {
+			i1, j1 := grid.geoToIndex(wall.Start.Y, wall.Start.X)
+			i2, j2 := grid.geoToIndex(wall.End.Y, wall.End.X)
+			edges = append(edges, [2]Point{{X: float64(i1), Y: float64(j1)}, {X: float64(i2), Y: float64(j2)}})
+		}
+		roofLevel := grid.HeightToLevel(building.Walls[0].Start.Z)
+		if roofLevel < 0 {
+			continue
+		}
+		footprints = append(footprints, footprint{building: buildingIndex, edges: edges, baseLevel: grid.HeightToLevel(building.MinHeight), roofLevel: roofLevel})
+	}
+	return footprints
+}
+
+// fillFootprint calls visit for every cell whose centre lies inside the
+// closed outline formed by edges (even-odd rule, scanline over the rows of
+// the matrix). Cells inside an inner ring are outside the outline.
+func fillFootprint(edges [][2]Point, sizeX, sizeY int, visit func(x, y int)) {
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, edge := range edges {
+		minY = math.Min(minY, math.Min(edge[0].Y, edge[1].Y))
+		maxY = math.Max(maxY, math.Max(edge[0].Y, edge[1].Y))
+	}
+	yStart := int(math.Max(0, math.Ceil(minY)))
+	yEnd := int(math.Min(float64(sizeY-1), math.Floor(maxY)))
+
+	crossings := make([]float64, 0, 8)
+	for y := yStart; y <= yEnd; y++ {
+		fy := float64(y)
+		crossings = crossings[:0]
+		for _, edge := range edges {
+			a, b := edge[0], edge[1]
+			if (a.Y <= fy && b.Y > fy) || (b.Y <= fy && a.Y > fy) {
+				crossings = append(crossings, a.X+(fy-a.Y)/(b.Y-a.Y)*(b.X-a.X))
+			}
+		}
+		sort.Float64s(crossings)
+		for k := 0; k+1 < len(crossings); k += 2 {
+			xStart := int(math.Max(0, math.Ceil(crossings[k])))
+			xEnd := int(math.Min(float64(sizeX-1), math.Floor(crossings[k+1])))
+			for x := xStart; x <= xEnd; x++ {
+				visit(x, y)
+			}
+		}
+	}
+}
+
+func isWallVoxel(value int16) bool {
+	return (value >= WallMapNumber && value < RoofMapNumber) || value == CornerMapNumber
+}
+
+// classifyBuildingVoxels labels roofs, roof corners and building interiors in
+// a matrix produced by generateBuildingMatrix. Interior cells below the roof
+// level become BuildingInteriorMapNumber, interior cells on the roof level
+// become RoofMapNumber and the topmost wall or corner voxel of every wall
+// column below the top of the matrix becomes RoofCornerMapNumber. Only empty
+// voxels are filled, so walls of neighbouring buildings are never overwritten.
+// As in the matrices processed by the earlier Python script, the roof lies on
+// the level of the top wall voxels, which become its corners.
+func classifyBuildingVoxels(matrix [][][]int16, footprints []footprint) {
+	if len(matrix) == 0 || len(matrix[0]) == 0 {
+		return
+	}
+	sizeZ := len(matrix)
+	sizeY := len(matrix[0])
+	sizeX := len(matrix[0][0])
+
+	for _, fp := range footprints {
+		fillFootprint(fp.edges, sizeX, sizeY, func(x, y int) {
+			for z := fp.baseLevel; z <= fp.roofLevel && z < sizeZ; z++ {
+				if matrix[z][y][x] != EmptyMapNumber {
+					continue
+				}
+				if z == fp.roofLevel {
+					matrix[z][y][x] = RoofMapNumber
+				} else {
+					matrix[z][y][x] = BuildingInteriorMapNumber
+				}
+			}
+		})
+	}
+
+	for y := 0; y < sizeY; y++ {
+		for x := 0; x < sizeX; x++ {
+			top := -1
+			for z := 0; z < sizeZ; z++ {
+				if isWallVoxel(matrix[z][y][x]) {
+					top = z
+				}
+			}
+			if top >= 0 && top < sizeZ-1 {
+				matrix[top][y][x] = RoofCornerMapNumber
+			}
+		}
+	}
+}
+
+// flipMatrixY mirrors every layer along the y axis so that row 0 is the
+// northern edge of the map, which is the orientation both the frontend and
+// RayLaunching3D expect.
+func flipMatrixY(matrix [][][]int16) {
+	for z := range matrix {
+		layer := matrix[z]
+		for top, bottom := 0, len(layer)-1; top < bottom; top, bottom = top+1, bottom-1 {
+			layer[top], layer[bottom] = layer[bottom], layer[top]
+		}
+	}
+}
+
+// ProcessWallsMatrix3D turns a raw walls matrix into the matrix used by
+// RayLaunching3D. The raw matrix is not modified.
+func ProcessWallsMatrix3D(rawMatrix [][][]int16, buildings []Building, mapConfig MapConfig) [][][]int16 {
+	processed := make([][][]int16, len(rawMatrix))
+	for z := range rawMatrix {
+		processed[z] = make([][]int16, len(rawMatrix[z]))
+		for y := range rawMatrix[z] {
+			processed[z][y] = append([]int16(nil), rawMatrix[z][y]...)
+		}
+	}
+	footprints := buildingFootprints(buildings, NewMapGrid(mapConfig))
+	classifyBuildingVoxels(processed, footprints)
+	flipMatrixY(processed)
+	fmt.Printf("classified %d building footprints\n", len(footprints))
+	return processed
+}
+
+// GenerateBuildingMap returns the index (into buildings) of the building that
+// covers every (y, x) column of the map, or -1 for open air. Columns inside a
+// footprint and wall columns are both assigned. The map uses the same
+// orientation as the processed matrix.
+func GenerateBuildingMap(rawMatrix [][][]int16, buildings []Building, wallInfo []WallInfo, mapConfig MapConfig) [][]int16 {
+	if len(rawMatrix) == 0 {
+		return nil
+	}
+	sizeY := len(rawMatrix[0])
+	sizeX := len(rawMatrix[0][0])
+	buildingMap := make([][]int16, sizeY)
+	for y := range buildingMap {
+		buildingMap[y] = make([]int16, sizeX)
+		for x := range buildingMap[y] {
+			buildingMap[y][x] = -1
+		}
+	}
+
+	footprints := buildingFootprints(buildings, NewMapGrid(mapConfig))
+	for _, fp := range footprints {
+		fillFootprint(fp.edges, sizeX, sizeY, func(x, y int) {
+			buildingMap[y][x] = int16(fp.building)
+		})
+	}
+	for y := 0; y < sizeY; y++ {
+		for x := 0; x < sizeX; x++ {
+			value := rawMatrix[0][y][x]
+			if value >= WallMapNumber && value < RoofMapNumber && int(value-WallMapNumber) < len(wallInfo) {
+				buildingMap[y][x] = int16(wallInfo[value-WallMapNumber].Building)
+			}
+		}
+	}
+
+	for top, bottom := 0, sizeY-1; top < bottom; top, bottom = top+1, bottom-1 {
+		buildingMap[top], buildingMap[bottom] = buildingMap[bottom], buildingMap[top]
+	}
+	return buildingMap
+}
@@ -0,0 +1,107 @@
+package calculations
+
+import (
+	. "backendGo/types"
+	"testing"
+)
+
+// ring returns the edges of the square from (min, min) to (max, max).
+func ring(min, max float64) [][2]Point {
+	corners := []Point{{X: min, Y: min}, {X: max, Y: min}, {X: max, Y: max}, {X: min, Y: max}}
+	edges := make([][2]Point, len(corners))
+	for i := range corners {
+		edges[i] = [2]Point{corners[i], corners[(i+1)%len(corners)]}
+	}
+	return edges
+}
+
+// TestClassifyBuildingVoxels labels a building with a courtyard: an outer
+// ring of walls from 2 to 9 and an inner ring from 4 to 7, both drawn up to
+// the roof level 3 like generateBuildingMatrix does, with corners where the
+// walls of a ring meet.
+func TestClassifyBuildingVoxels(t *testing.T) {
+	const size, sizeZ, roofLevel = 12, 6, 3
+	matrix := make([][][]int16, sizeZ)
+	for z := range matrix {
+		matrix[z] = make([][]int16, size)
+		for y := range matrix[z] {
+			matrix[z][y] = make([]int16, size)
+			for x := range matrix[z][y] {
+				matrix[z][y][x] = EmptyMapNumber
+			}
+		}
+	}
+	edges := append(ring(2, 9), ring(4, 7)...)
+	wall := func(x, y int) int16 {
+		for i, edge := range edges {
+			a, b := edge[0], edge[1]
+			minX, maxX := int(min(a.X, b.X)), int(max(a.X, b.X))
+			minY, maxY := int(min(a.Y, b.Y)), int(max(a.Y, b.Y))
+			if x >= minX && x <= maxX && y >= minY && y <= maxY {
+				if (x == minX || x == maxX) && (y == minY || y == maxY) {
+					return CornerMapNumber
+				}
+				return WallMapNumber + int16(i)
+			}
+		}
+		return EmptyMapNumber
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if label := wall(x, y); label != EmptyMapNumber {
+				for z := 0; z <= roofLevel; z++ {
+					matrix[z][y][x] = label
+				}
+			}
+		}
+	}
+
+	classifyBuildingVoxels(matrix, []footprint{{edges: edges, baseLevel: 0, roofLevel: roofLevel}})
+
+	inside := func(x, y, min, max int) bool { return x > min && x < max && y > min && y < max }
+	for z := 0; z < sizeZ; z++ {
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				want := int16(EmptyMapNumber)
+				switch label := wall(x, y); {
+				case label != EmptyMapNumber && z < roofLevel:
+					want = label
+				case label != EmptyMapNumber && z == roofLevel:
+					// the topmost wall and corner voxels are the edge of the roof
+					want = RoofCornerMapNumber
+				case inside(x, y, 2, 9) && !inside(x, y, 4, 7) && z < roofLevel:
+					want = BuildingInteriorMapNumber
+				case inside(x, y, 2, 9) && !inside(x, y, 4, 7) && z == roofLevel:
+					// the roof lies on the level of the top of the walls
+					want = RoofMapNumber
+				}
+				if got := matrix[z][y][x]; got != want {
+					t.Errorf("voxel (%d, %d, %d) = %d, want %d", x, y, z, got, want)
+				}
+			}
+		}
+	}
+}
+
+// TestFillFootprint checks the cells off the outline of a square with a
+// courtyard, the cells on the outline carry the walls and may be visited or not.
+func TestFillFootprint(t *testing.T) {
+	visited := make(map[[2]int]bool)
+	fillFootprint(append(ring(2, 9), ring(4, 7)...), 12, 12, func(x, y int) {
+		visited[[2]int{x, y}] = true
+	})
+	onOutline := func(x, y, min, max int) bool {
+		return (x == min || x == max) && y >= min && y <= max || (y == min || y == max) && x >= min && x <= max
+	}
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 12; x++ {
+			if onOutline(x, y, 2, 9) || onOutline(x, y, 4, 7) {
+				continue
+			}
+			want := x > 2 && x < 9 && y > 2 && y < 9 && !(x > 4 && x < 7 && y > 4 && y < 7)
+			if visited[[2]int{x, y}] != want {
+				t.Errorf("cell (%d, %d) visited %t, want %t", x, y, visited[[2]int{x, y}], want)
+			}
+		}
+	}
+}
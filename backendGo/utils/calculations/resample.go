@@ -0,0 +1,112 @@
+package calculations
+
+// resamplePriority orders the voxel labels kept when several labels meet in
+// one resampled voxel: edges first, so that diffraction still happens, then
+// walls and roofs, so that rays keep reflecting, then interiors.
+func resamplePriority(label int16) int {
+	switch {
+	case label == RoofCornerMapNumber:
+		return 5
+	case label == CornerMapNumber:
+		return 4
+	case label >= WallMapNumber && label < RoofMapNumber:
+		return 3
+	case label == RoofMapNumber:
+		return 2
+	case label == BuildingInteriorMapNumber:
+		return 1
+	}
+	return 0
+}
+
+// resampleBlock returns the range of source indices that resampled index i
+// covers: the stride points centred on i*stride, clipped to size.
+func resampleBlock(i, stride, size int) (int, int) {
+	start := i*stride - stride/2
+	end := start + stride
+	if start < 0 {
+		start = 0
+	}
+	if end > size {
+		end = size
+	}
+	return start, end
+}
+
+// processedRows returns the rows of a processed (north up) layer of sizeY
+// rows that resampled row y of sizeYOut rows covers. Blocks are aligned on
+// the southern edge like the cells of MapGrid.Resample.
+func processedRows(y, sizeYOut, sizeY, stride int) (int, int) {
+	start, end := resampleBlock(sizeYOut-1-y, stride, sizeY)
+	return sizeY - end, sizeY - start
+}
+
+// ResampleProcessedMatrix3D resamples a processed matrix to the grid returned
+// by MapGrid.Resample with the same strides. Every voxel gets the label of
+// the highest priority among the voxels of its block (see resamplePriority),
+// so thin walls survive. Wall labels keep their index into the wall normals.
+func ResampleProcessedMatrix3D(matrix [][][]int16, stride, levelStride int) [][][]int16 {
+	if len(matrix) == 0 || len(matrix[0]) == 0 || (stride == 1 && levelStride == 1) {
+		return matrix
+	}
+	sizeZ, sizeY, sizeX := len(matrix), len(matrix[0]), len(matrix[0][0])
+	sizeZOut := resampledSize(sizeZ, levelStride)
+	sizeYOut := resampledSize(sizeY, stride)
+	sizeXOut := resampledSize(sizeX, stride)
+
+	resampled := make([][][]int16, sizeZOut)
+	for z := range resampled {
+		zStart, zEnd := resampleBlock(z, levelStride, sizeZ)
+		resampled[z] = make([][]int16, sizeYOut)
+		for y := range resampled[z] {
+			yStart, yEnd := processedRows(y, sizeYOut, sizeY, stride)
+			resampled[z][y] = make([]int16, sizeXOut)
+			for x := range resampled[z][y] {
+				xStart, xEnd := resampleBlock(x, stride, sizeX)
+				label, priority := int16(EmptyMapNumber), 0
+				for zz := zStart; zz < zEnd; zz++ {
+					for yy := yStart; yy < yEnd; yy++ {
+						for xx := xStart; xx < xEnd; xx++ {
+							if p := resamplePriority(matrix[zz][yy][xx]); p > priority {
+								label, priority = matrix[zz][yy][xx], p
+							}
+						}
+					}
+				}
+				resampled[z][y][x] = label
+			}
+		}
+	}
+	return resampled
+}
+
+// ResampleBuildingMap resamples a building map like ResampleProcessedMatrix3D
+// resamples the matrix. A cell takes the building of the centre of its block,
+// or any building of the block when the centre is open air.
+func ResampleBuildingMap(buildingMap [][]int16, stride int) [][]int16 {
+	if len(buildingMap) == 0 || stride == 1 {
+		return buildingMap
+	}
+	sizeY, sizeX := len(buildingMap), len(buildingMap[0])
+	sizeYOut, sizeXOut := resampledSize(sizeY, stride), resampledSize(sizeX, stride)
+	resampled := make([][]int16, sizeYOut)
+	for y := range resampled {
+		yStart, yEnd := processedRows(y, sizeYOut, sizeY, stride)
+		centreY := sizeY - 1 - (sizeYOut-1-y)*stride
+		resampled[y] = make([]int16, sizeXOut)
+		for x := range resampled[y] {
+			building := int16(-1)
+			if centreX := x * stride; centreY >= 0 && centreX < sizeX {
+				building = buildingMap[centreY][centreX]
+			}
+			xStart, xEnd := resampleBlock(x, stride, sizeX)
+			for yy := yStart; yy < yEnd && building < 0; yy++ {
+				for xx := xStart; xx < xEnd && building < 0; xx++ {
+					building = buildingMap[yy][xx]
+				}
+			}
+			resampled[y][x] = building
+		}
+	}
+	return resampled
+}
@@ -0,0 +1,239 @@
+// Package osm reads building footprints from OpenStreetMap extracts in XML
+// (.osm) or PBF (.osm.pbf) format.
+package osm
+
+import (
+	"fmt"
+	"strings"
+)
+
+type BBox struct {
+	MinLat, MaxLat, MinLon, MaxLon float64
+}
+
+func (b BBox) contains(lat, lon float64) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && lon >= b.MinLon && lon <= b.MaxLon
+}
+
+type Member struct {
+	Type string // node, way or relation
+	Ref  int64
+	Role string
+}
+
+type Way struct {
+	ID   int64
+	Refs []int64
+	Tags map[string]string
+}
+
+type Relation struct {
+	ID      int64
+	Members []Member
+	Tags    map[string]string
+}
+
+// visitor receives the elements of an extract. Nil callbacks let the readers
+// skip the elements of that kind.
+type visitor struct {
+	node     func(id int64, lat, lon float64)
+	way      func(way *Way)
+	relation func(relation *Relation)
+}
+
+// Footprint is the outline of a building with the tags of the building. Every
+// polygon is a closed outer ring of [lon, lat] followed by its inner rings.
+type Footprint struct {
+	ID       string // "way/<id>" or "relation/<id>"
+	Tags     map[string]string
+	Polygons [][][][2]float64
+}
+
+func isBuilding(tags map[string]string) bool {
+	value, ok := tags["building"]
+	return ok && value != "no"
+}
+
+func scan(path string, v visitor) error {
+	switch {
+	case strings.HasSuffix(path, ".osm.pbf") || strings.HasSuffix(path, ".pbf"):
+		return scanPBF(path, v)
+	case strings.HasSuffix(path, ".osm") || strings.HasSuffix(path, ".xml"):
+		return scanXML(path, v)
+	}
+	return fmt.Errorf("unsupported OSM file %s, expected .osm or .osm.pbf", path)
+}
+
+// LoadBuildingFootprints returns the buildings (building=* ways and
+// multipolygon relations) of the extract at path that have at least one node
+// inside bbox. The file is read three times, for relations, ways and nodes,
+// so that only the nodes of buildings are kept in memory.
+func LoadBuildingFootprints(path string, bbox BBox) ([]Footprint, error) {
+	var relations []*Relation
+	memberWays := make(map[int64]bool)
+	err := scan(path, visitor{relation: func(relation *Relation) {
+		if relation.Tags["type"] != "multipolygon" || !isBuilding(relation.Tags) {
+			return
+		}
+		relations = append(relations, relation)
+		for _, member := range relation.Members {
+			if member.Type == "way" {
+				memberWays[member.Ref] = true
+			}
+		}
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	var buildingWays []*Way
+	ways := make(map[int64]*Way)
+	neededNodes := make(map[int64][2]float64)
+	err = scan(path, visitor{way: func(way *Way) {
+		building := isBuilding(way.Tags) && len(way.Refs) >= 4 && way.Refs[0] == way.Refs[len(way.Refs)-1]
+		if !building && !memberWays[way.ID] {
+			return
+		}
+		if building {
+			buildingWays = append(buildingWays, way)
+		}
+		if memberWays[way.ID] {
+			ways[way.ID] = way
+		}
+		for _, ref := range way.Refs {
+			neededNodes[ref] = [2]float64{}
+		}
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scan(path, visitor{node: func(id int64, lat, lon float64) {
+		if _, ok := neededNodes[id]; ok {
+			neededNodes[id] = [2]float64{lon, lat}
+		}
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	var footprints []Footprint
+	ringCoordinates := func(refs []int64) ([][2]float64, bool) {
+		ring := make([][2]float64, 0, len(refs))
+		for _, ref := range refs {
+			coords, ok := neededNodes[ref]
+			if !ok || coords == ([2]float64{}) {
+				return nil, false // node missing from the extract
+			}
+			ring = append(ring, coords)
+		}
+		return ring, true
+	}
+	addFootprint := func(id string, tags map[string]string, polygons [][][][2]float64) {
+		for _, polygon := range polygons {
+			for _, point := range polygon[0] {
+				if bbox.contains(point[1], point[0]) {
+					footprints = append(footprints, Footprint{ID: id, Tags: tags, Polygons: polygons})
+					return
+				}
+			}
+		}
+	}
+	for _, way := range buildingWays {
+		if ring, ok := ringCoordinates(way.Refs); ok {
+			addFootprint(fmt.Sprintf("way/%d", way.ID), way.Tags, [][][][2]float64{{ring}})
+		}
+	}
+	for _, relation := range relations {
+		var outerSegments, innerSegments [][]int64
+		for _, member := range relation.Members {
+			way, ok := ways[member.Ref]
+			if !ok || member.Type != "way" {
+				continue
+			}
+			if member.Role == "inner" {
+				innerSegments = append(innerSegments, way.Refs)
+			} else {
+				outerSegments = append(outerSegments, way.Refs)
+			}
+		}
+		var polygons [][][][2]float64
+		for _, refs := range assembleRings(outerSegments) {
+			if ring, ok := ringCoordinates(refs); ok {
+				polygons = append(polygons, [][][2]float64{ring})
+			}
+		}
+		for _, refs := range assembleRings(innerSegments) {
+			ring, ok := ringCoordinates(refs)
+			if !ok {
+				continue
+			}
+			for i := range polygons {
+				if containsPoint(polygons[i][0], ring[0]) {
+					polygons[i] = append(polygons[i], ring)
+					break
+				}
+			}
+		}
+		if len(polygons) > 0 {
+			addFootprint(fmt.Sprintf("relation/%d", relation.ID), relation.Tags, polygons)
+		}
+	}
+	return footprints, nil
+}
+
+// containsPoint tests point against ring with the even-odd rule.
+func containsPoint(ring [][2]float64, point [2]float64) bool {
+	inside := false
+	for i := range ring {
+		a, b := ring[i], ring[(i+1)%len(ring)]
+		if (a[1] > point[1]) != (b[1] > point[1]) &&
+			point[0] < a[0]+(point[1]-a[1])/(b[1]-a[1])*(b[0]-a[0]) {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// assembleRings joins way segments that share end nodes into closed rings.
+// Segments that do not close are dropped.
+func assembleRings(segments [][]int64) [][]int64 {
+	used := make([]bool, len(segments))
+	var rings [][]int64
+	for i, segment := range segments {
+		if used[i] || len(segment) < 2 {
+			continue
+		}
+		used[i] = true
+		ring := append([]int64(nil), segment...)
+		for ring[0] != ring[len(ring)-1] {
+			extended := false
+			for j, next := range segments {
+				if used[j] || len(next) < 2 {
+					continue
+				}
+				end := ring[len(ring)-1]
+				switch end {
+				case next[0]:
+					ring = append(ring, next[1:]...)
+				case next[len(next)-1]:
+					for k := len(next) - 2; k >= 0; k-- {
+						ring = append(ring, next[k])
+					}
+				default:
+					continue
+				}
+				used[j] = true
+				extended = true
+				break
+			}
+			if !extended {
+				break
+			}
+		}
+		if len(ring) >= 4 && ring[0] == ring[len(ring)-1] {
+			rings = append(rings, ring)
+		}
+	}
+	return rings
+}
@@ -0,0 +1,144 @@
+package osm
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// fixtureBBox holds the way building and the multipolygon relation of the
+// fixtures but not the second way building further north.
+var fixtureBBox = BBox{MinLat: 50.06, MaxLat: 50.07, MinLon: 19.91, MaxLon: 19.93}
+
+// testdata/buildings.osm.pbf is written from testdata/buildings.osm by
+// testdata/generate.go.
+var fixtures = []string{"testdata/buildings.osm", "testdata/buildings.osm.pbf"}
+
+func loadFixture(t *testing.T, path string) []Footprint {
+	t.Helper()
+	footprints, err := LoadBuildingFootprints(path, fixtureBBox)
+	if err != nil {
+		t.Fatalf("LoadBuildingFootprints(%s): %v", path, err)
+	}
+	return footprints
+}
+
+func TestLoadBuildingFootprints(t *testing.T) {
+	for _, path := range fixtures {
+		t.Run(path, func(t *testing.T) {
+			footprints := loadFixture(t, path)
+			if len(footprints) != 2 {
+				t.Fatalf("got %d footprints, want 2", len(footprints))
+			}
+
+			way := footprints[0]
+			if way.ID != "way/100" {
+				t.Errorf("first footprint is %s, want way/100", way.ID)
+			}
+			wantTags := map[string]string{"building": "yes", "building:levels": "3", "name": "Pawilon A"}
+			if !reflect.DeepEqual(way.Tags, wantTags) {
+				t.Errorf("way tags = %v, want %v", way.Tags, wantTags)
+			}
+			if len(way.Polygons) != 1 || len(way.Polygons[0]) != 1 || len(way.Polygons[0][0]) != 5 {
+				t.Fatalf("way polygons = %v, want one ring of 5 points", way.Polygons)
+			}
+			if first := way.Polygons[0][0][0]; !closeTo(first, [2]float64{19.92, 50.065}) {
+				t.Errorf("way starts at %v, want [19.92 50.065]", first)
+			}
+
+			relation := footprints[1]
+			if relation.ID != "relation/200" {
+				t.Errorf("second footprint is %s, want relation/200", relation.ID)
+			}
+			if relation.Tags["building"] != "university" || relation.Tags["height"] != "18" {
+				t.Errorf("relation tags = %v", relation.Tags)
+			}
+			if len(relation.Polygons) != 1 {
+				t.Fatalf("relation has %d polygons, want 1", len(relation.Polygons))
+			}
+			polygon := relation.Polygons[0]
+			if len(polygon) != 2 {
+				t.Fatalf("relation polygon has %d rings, want an outer and an inner ring", len(polygon))
+			}
+			// the outer ring is joined from way 201 and the reversed way 202
+			wantOuter := [][2]float64{{19.921, 50.066}, {19.9218, 50.066}, {19.9218, 50.0665}, {19.921, 50.0665}, {19.921, 50.066}}
+			if !ringsClose(polygon[0], wantOuter) {
+				t.Errorf("outer ring = %v, want %v", polygon[0], wantOuter)
+			}
+			if len(polygon[1]) != 5 || !containsPoint(polygon[0], polygon[1][0]) {
+				t.Errorf("inner ring = %v, want a closed ring inside the outer ring", polygon[1])
+			}
+		})
+	}
+}
+
+func TestFormatsGiveSameFootprints(t *testing.T) {
+	fromXML := loadFixture(t, fixtures[0])
+	fromPBF := loadFixture(t, fixtures[1])
+	if len(fromXML) != len(fromPBF) {
+		t.Fatalf("got %d footprints from XML and %d from PBF", len(fromXML), len(fromPBF))
+	}
+	for i := range fromXML {
+		a, b := fromXML[i], fromPBF[i]
+		if a.ID != b.ID || !reflect.DeepEqual(a.Tags, b.Tags) {
+			t.Errorf("footprint %d: XML %s %v, PBF %s %v", i, a.ID, a.Tags, b.ID, b.Tags)
+		}
+		if len(a.Polygons) != len(b.Polygons) {
+			t.Errorf("%s: %d polygons from XML, %d from PBF", a.ID, len(a.Polygons), len(b.Polygons))
+			continue
+		}
+		for j := range a.Polygons {
+			if len(a.Polygons[j]) != len(b.Polygons[j]) {
+				t.Errorf("%s polygon %d: %d rings from XML, %d from PBF", a.ID, j, len(a.Polygons[j]), len(b.Polygons[j]))
+				continue
+			}
+			for k := range a.Polygons[j] {
+				if !ringsClose(a.Polygons[j][k], b.Polygons[j][k]) {
+					t.Errorf("%s polygon %d ring %d: XML %v, PBF %v", a.ID, j, k, a.Polygons[j][k], b.Polygons[j][k])
+				}
+			}
+		}
+	}
+}
+
+func TestAssembleRings(t *testing.T) {
+	segments := [][]int64{{1, 2, 3}, {5, 6}, {1, 4, 3}, {7, 8, 9, 7}}
+	got := assembleRings(segments)
+	want := [][]int64{{1, 2, 3, 4, 1}, {7, 8, 9, 7}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("assembleRings(%v) = %v, want %v", segments, got, want)
+	}
+}
+
+func TestContainsPoint(t *testing.T) {
+	ring := [][2]float64{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}}
+	for _, test := range []struct {
+		point [2]float64
+		want  bool
+	}{
+		{[2]float64{2, 2}, true},
+		{[2]float64{5, 2}, false},
+		{[2]float64{2, -1}, false},
+	} {
+		if got := containsPoint(ring, test.point); got != test.want {
+			t.Errorf("containsPoint(%v) = %v, want %v", test.point, got, test.want)
+		}
+	}
+}
+
+// closeTo compares coordinates up to the PBF granularity of 100 nanodegrees.
+func closeTo(a, b [2]float64) bool {
+	return math.Abs(a[0]-b[0]) < 1e-8 && math.Abs(a[1]-b[1]) < 1e-8
+}
+
+func ringsClose(a, b [][2]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !closeTo(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
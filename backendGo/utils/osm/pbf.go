@@ -0,0 +1,470 @@
+package osm
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The PBF format is a sequence of blobs, each preceded by the big endian
+// length of its BlobHeader. The messages are decoded by hand with the field
+// numbers of fileformat.proto and osmformat.proto.
+
+const (
+	maxBlobHeaderSize = 64 * 1024
+	maxBlobSize       = 32 * 1024 * 1024
+)
+
+var supportedFeatures = map[string]bool{
+	"OsmSchema-V0.6": true,
+	"DenseNodes":     true,
+}
+
+// pbField is one decoded protobuf field. Varints are stored in value,
+// length delimited fields in data.
+type pbField struct {
+	number int
+	wire   int
+	value  uint64
+	data   []byte
+}
+
+type pbReader struct {
+	buf []byte
+	err error
+}
+
+func (r *pbReader) varint() uint64 {
+	value, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errors.New("malformed varint")
+		r.buf = nil
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return value
+}
+
+func (r *pbReader) next() (pbField, bool) {
+	if r.err != nil || len(r.buf) == 0 {
+		return pbField{}, false
+	}
+	key := r.varint()
+	field := pbField{number: int(key >> 3), wire: int(key & 7)}
+	switch field.wire {
+	case 0:
+		field.value = r.varint()
+	case 1:
+		if len(r.buf) < 8 {
+			r.err = errors.New("truncated fixed64")
+			return pbField{}, false
+		}
+		field.value = binary.LittleEndian.Uint64(r.buf)
+		r.buf = r.buf[8:]
+	case 2:
+		length := r.varint()
+		if r.err == nil && length > uint64(len(r.buf)) {
+			r.err = errors.New("truncated field")
+		}
+		if r.err != nil {
+			return pbField{}, false
+		}
+		field.data = r.buf[:length]
+		r.buf = r.buf[length:]
+	case 5:
+		if len(r.buf) < 4 {
+			r.err = errors.New("truncated fixed32")
+			return pbField{}, false
+		}
+		field.value = uint64(binary.LittleEndian.Uint32(r.buf))
+		r.buf = r.buf[4:]
+	default:
+		r.err = fmt.Errorf("unsupported wire type %d", field.wire)
+		return pbField{}, false
+	}
+	return field, r.err == nil
+}
+
+func zigzag(value uint64) int64 {
+	return int64(value>>1) ^ -int64(value&1)
+}
+
+// uints returns the values of a repeated varint field, packed or not.
+func (f pbField) uints() ([]uint64, error) {
+	if f.wire == 0 {
+		return []uint64{f.value}, nil
+	}
+	r := pbReader{buf: f.data}
+	var values []uint64
+	for len(r.buf) > 0 && r.err == nil {
+		values = append(values, r.varint())
+	}
+	return values, r.err
+}
+
+// sints returns the zigzag decoded values of a repeated sint64 field.
+func (f pbField) sints() ([]int64, error) {
+	values, err := f.uints()
+	result := make([]int64, len(values))
+	for i, value := range values {
+		result[i] = zigzag(value)
+	}
+	return result, err
+}
+
+func scanPBF(path string, v visitor) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 1<<20)
+	for {
+		var headerSize uint32
+		if err := binary.Read(reader, binary.BigEndian, &headerSize); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error reading %s: %w", path, err)
+		}
+		if headerSize > maxBlobHeaderSize {
+			return fmt.Errorf("error reading %s: blob header of %d bytes", path, headerSize)
+		}
+		header := make([]byte, headerSize)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return fmt.Errorf("error reading %s: %w", path, err)
+		}
+
+		var blobType string
+		var blobSize uint64
+		r := pbReader{buf: header}
+		for field, ok := r.next(); ok; field, ok = r.next() {
+			switch field.number {
+			case 1:
+				blobType = string(field.data)
+			case 3:
+				blobSize = field.value
+			}
+		}
+		if r.err != nil {
+			return fmt.Errorf("error reading %s: blob header: %w", path, r.err)
+		}
+		if blobSize > maxBlobSize {
+			return fmt.Errorf("error reading %s: blob of %d bytes", path, blobSize)
+		}
+		blob := make([]byte, blobSize)
+		if _, err := io.ReadFull(reader, blob); err != nil {
+			return fmt.Errorf("error reading %s: %w", path, err)
+		}
+
+		switch blobType {
+		case "OSMHeader":
+			data, err := blobData(blob)
+			if err == nil {
+				err = checkHeaderBlock(data)
+			}
+			if err != nil {
+				return fmt.Errorf("error reading %s: %w", path, err)
+			}
+		case "OSMData":
+			data, err := blobData(blob)
+			if err == nil {
+				err = readPrimitiveBlock(data, v)
+			}
+			if err != nil {
+				return fmt.Errorf("error reading %s: %w", path, err)
+			}
+		}
+	}
+}
+
+// blobData returns the uncompressed content of a Blob message.
+func blobData(blob []byte) ([]byte, error) {
+	var rawSize uint64
+	r := pbReader{buf: blob}
+	for field, ok := r.next(); ok; field, ok = r.next() {
+		switch field.number {
+		case 1:
+			return field.data, nil
+		case 2:
+			rawSize = field.value
+		case 3:
+			if rawSize > maxBlobSize {
+				return nil, fmt.Errorf("blob of %d bytes", rawSize)
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(field.data))
+			if err != nil {
+				return nil, err
+			}
+			defer zr.Close()
+			data := bytes.NewBuffer(make([]byte, 0, rawSize))
+			if _, err := io.Copy(data, io.LimitReader(zr, maxBlobSize+1)); err != nil {
+				return nil, err
+			}
+			return data.Bytes(), nil
+		case 4, 5, 6, 7:
+			return nil, fmt.Errorf("unsupported blob compression (field %d), only zlib is supported", field.number)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return nil, errors.New("empty blob")
+}
+
+func checkHeaderBlock(data []byte) error {
+	r := pbReader{buf: data}
+	for field, ok := r.next(); ok; field, ok = r.next() {
+		if field.number == 4 && !supportedFeatures[string(field.data)] {
+			return fmt.Errorf("unsupported required feature %s", field.data)
+		}
+	}
+	return r.err
+}
+
+// primitiveBlock holds the state shared by the groups of a PrimitiveBlock.
+type primitiveBlock struct {
+	strings     [][]byte
+	granularity int64
+	latOffset   int64
+	lonOffset   int64
+}
+
+func (b *primitiveBlock) coordinate(offset, value int64) float64 {
+	return 1e-9 * float64(offset+b.granularity*value)
+}
+
+func (b *primitiveBlock) string(index uint64) (string, error) {
+	if index >= uint64(len(b.strings)) {
+		return "", fmt.Errorf("string index %d out of range", index)
+	}
+	return string(b.strings[index]), nil
+}
+
+func (b *primitiveBlock) tags(keys, values []uint64) (map[string]string, error) {
+	if len(keys) != len(values) {
+		return nil, errors.New("tag keys and values differ in length")
+	}
+	tags := make(map[string]string, len(keys))
+	for i := range keys {
+		key, err := b.string(keys[i])
+		if err != nil {
+			return nil, err
+		}
+		value, err := b.string(values[i])
+		if err != nil {
+			return nil, err
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+func readPrimitiveBlock(data []byte, v visitor) error {
+	block := primitiveBlock{granularity: 100}
+	var groups [][]byte
+	r := pbReader{buf: data}
+	for field, ok := r.next(); ok; field, ok = r.next() {
+		switch field.number {
+		case 1:
+			s := pbReader{buf: field.data}
+			for entry, ok := s.next(); ok; entry, ok = s.next() {
+				if entry.number == 1 {
+					block.strings = append(block.strings, entry.data)
+				}
+			}
+			if s.err != nil {
+				return s.err
+			}
+		case 2:
+			groups = append(groups, field.data)
+		case 17:
+			block.granularity = int64(field.value)
+		case 19:
+			block.latOffset = int64(field.value)
+		case 20:
+			block.lonOffset = int64(field.value)
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	for _, group := range groups {
+		g := pbReader{buf: group}
+		for field, ok := g.next(); ok; field, ok = g.next() {
+			var err error
+			switch {
+			case field.number == 1 && v.node != nil:
+				err = block.readNode(field.data, v)
+			case field.number == 2 && v.node != nil:
+				err = block.readDenseNodes(field.data, v)
+			case field.number == 3 && v.way != nil:
+				err = block.readWay(field.data, v)
+			case field.number == 4 && v.relation != nil:
+				err = block.readRelation(field.data, v)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if g.err != nil {
+			return g.err
+		}
+	}
+	return nil
+}
+
+func (b *primitiveBlock) readNode(data []byte, v visitor) error {
+	var id, lat, lon int64
+	r := pbReader{buf: data}
+	for field, ok := r.next(); ok; field, ok = r.next() {
+		switch field.number {
+		case 1:
+			id = zigzag(field.value)
+		case 8:
+			lat = zigzag(field.value)
+		case 9:
+			lon = zigzag(field.value)
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	v.node(id, b.coordinate(b.latOffset, lat), b.coordinate(b.lonOffset, lon))
+	return nil
+}
+
+func (b *primitiveBlock) readDenseNodes(data []byte, v visitor) error {
+	var ids, lats, lons []int64
+	r := pbReader{buf: data}
+	for field, ok := r.next(); ok; field, ok = r.next() {
+		var err error
+		switch field.number {
+		case 1:
+			ids, err = field.sints()
+		case 8:
+			lats, err = field.sints()
+		case 9:
+			lons, err = field.sints()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if len(lats) != len(ids) || len(lons) != len(ids) {
+		return errors.New("dense nodes with inconsistent lengths")
+	}
+	var id, lat, lon int64
+	for i := range ids {
+		id += ids[i]
+		lat += lats[i]
+		lon += lons[i]
+		v.node(id, b.coordinate(b.latOffset, lat), b.coordinate(b.lonOffset, lon))
+	}
+	return nil
+}
+
+func (b *primitiveBlock) readWay(data []byte, v visitor) error {
+	way := &Way{}
+	var keys, values []uint64
+	var refs []int64
+	r := pbReader{buf: data}
+	for field, ok := r.next(); ok; field, ok = r.next() {
+		var err error
+		switch field.number {
+		case 1:
+			way.ID = int64(field.value)
+		case 2:
+			keys, err = field.uints()
+		case 3:
+			values, err = field.uints()
+		case 8:
+			refs, err = field.sints()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	tags, err := b.tags(keys, values)
+	if err != nil {
+		return err
+	}
+	way.Tags = tags
+	way.Refs = make([]int64, len(refs))
+	var ref int64
+	for i, delta := range refs {
+		ref += delta
+		way.Refs[i] = ref
+	}
+	v.way(way)
+	return nil
+}
+
+func (b *primitiveBlock) readRelation(data []byte, v visitor) error {
+	relation := &Relation{}
+	var keys, values, roles, types []uint64
+	var memberIds []int64
+	r := pbReader{buf: data}
+	for field, ok := r.next(); ok; field, ok = r.next() {
+		var err error
+		switch field.number {
+		case 1:
+			relation.ID = int64(field.value)
+		case 2:
+			keys, err = field.uints()
+		case 3:
+			values, err = field.uints()
+		case 8:
+			roles, err = field.uints()
+		case 9:
+			memberIds, err = field.sints()
+		case 10:
+			types, err = field.uints()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if len(roles) != len(memberIds) || len(types) != len(memberIds) {
+		return errors.New("relation members with inconsistent lengths")
+	}
+	tags, err := b.tags(keys, values)
+	if err != nil {
+		return err
+	}
+	relation.Tags = tags
+	relation.Members = make([]Member, len(memberIds))
+	var ref int64
+	for i := range memberIds {
+		ref += memberIds[i]
+		role, err := b.string(roles[i])
+		if err != nil {
+			return err
+		}
+		memberType := "node"
+		switch types[i] {
+		case 1:
+			memberType = "way"
+		case 2:
+			memberType = "relation"
+		}
+		relation.Members[i] = Member{Type: memberType, Ref: ref, Role: role}
+	}
+	v.relation(relation)
+	return nil
+}
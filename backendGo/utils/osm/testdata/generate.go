@@ -0,0 +1,210 @@
+//go:build ignore
+
+// generate writes buildings.osm.pbf with the nodes, ways and relations of
+// buildings.osm, in one zlib compressed OSMData blob with dense nodes. Run it
+// from utils/osm with go run testdata/generate.go.
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"log"
+	"math"
+	"os"
+)
+
+type tag struct {
+	Key   string `xml:"k,attr"`
+	Value string `xml:"v,attr"`
+}
+
+type extract struct {
+	Nodes []struct {
+		ID  int64   `xml:"id,attr"`
+		Lat float64 `xml:"lat,attr"`
+		Lon float64 `xml:"lon,attr"`
+	} `xml:"node"`
+	Ways []struct {
+		ID   int64 `xml:"id,attr"`
+		Refs []struct {
+			Ref int64 `xml:"ref,attr"`
+		} `xml:"nd"`
+		Tags []tag `xml:"tag"`
+	} `xml:"way"`
+	Relations []struct {
+		ID      int64 `xml:"id,attr"`
+		Members []struct {
+			Type string `xml:"type,attr"`
+			Ref  int64  `xml:"ref,attr"`
+			Role string `xml:"role,attr"`
+		} `xml:"member"`
+		Tags []tag `xml:"tag"`
+	} `xml:"relation"`
+}
+
+// message appends protobuf fields to a buffer.
+type message []byte
+
+func (m *message) key(number, wire int) {
+	*m = binary.AppendUvarint(*m, uint64(number<<3|wire))
+}
+
+func (m *message) varint(number int, value uint64) {
+	m.key(number, 0)
+	*m = binary.AppendUvarint(*m, value)
+}
+
+func (m *message) bytes(number int, data []byte) {
+	m.key(number, 2)
+	*m = binary.AppendUvarint(*m, uint64(len(data)))
+	*m = append(*m, data...)
+}
+
+func (m *message) packed(number int, values []uint64) {
+	var data []byte
+	for _, value := range values {
+		data = binary.AppendUvarint(data, value)
+	}
+	m.bytes(number, data)
+}
+
+func zigzag(value int64) uint64 {
+	return uint64(value<<1) ^ uint64(value>>63)
+}
+
+// deltas returns the zigzag encoded differences of consecutive values.
+func deltas(values []int64) []uint64 {
+	encoded := make([]uint64, len(values))
+	var previous int64
+	for i, value := range values {
+		encoded[i] = zigzag(value - previous)
+		previous = value
+	}
+	return encoded
+}
+
+// stringTable numbers the strings of a block, index 0 is the empty string.
+type stringTable struct {
+	strings []string
+	index   map[string]uint64
+}
+
+func (t *stringTable) id(s string) uint64 {
+	if t.index == nil {
+		t.strings, t.index = []string{""}, map[string]uint64{"": 0}
+	}
+	if id, ok := t.index[s]; ok {
+		return id
+	}
+	t.index[s] = uint64(len(t.strings))
+	t.strings = append(t.strings, s)
+	return t.index[s]
+}
+
+func (t *stringTable) tags(m *message, tags []tag) {
+	keys := make([]uint64, len(tags))
+	values := make([]uint64, len(tags))
+	for i, tag := range tags {
+		keys[i], values[i] = t.id(tag.Key), t.id(tag.Value)
+	}
+	m.packed(2, keys)
+	m.packed(3, values)
+}
+
+// blob returns a BlobHeader and a zlib compressed Blob of data.
+func blob(blobType string, data []byte) []byte {
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(data)
+	w.Close()
+
+	var body message
+	body.varint(2, uint64(len(data)))
+	body.bytes(3, compressed.Bytes())
+	var header message
+	header.bytes(1, []byte(blobType))
+	header.varint(3, uint64(len(body)))
+
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(header)))
+	out = append(out, header...)
+	return append(out, body...)
+}
+
+func main() {
+	data, err := os.ReadFile("testdata/buildings.osm")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var osm extract
+	if err := xml.Unmarshal(data, &osm); err != nil {
+		log.Fatal(err)
+	}
+
+	var strings stringTable
+	var dense message
+	ids := make([]int64, len(osm.Nodes))
+	lats := make([]int64, len(osm.Nodes))
+	lons := make([]int64, len(osm.Nodes))
+	for i, node := range osm.Nodes {
+		// granularity 100 nanodegrees
+		ids[i], lats[i], lons[i] = node.ID, int64(math.Round(node.Lat*1e7)), int64(math.Round(node.Lon*1e7))
+	}
+	dense.packed(1, deltas(ids))
+	dense.packed(8, deltas(lats))
+	dense.packed(9, deltas(lons))
+	var nodeGroup message
+	nodeGroup.bytes(2, dense)
+
+	var wayGroup message
+	for _, way := range osm.Ways {
+		var w message
+		w.varint(1, uint64(way.ID))
+		strings.tags(&w, way.Tags)
+		refs := make([]int64, len(way.Refs))
+		for i, nd := range way.Refs {
+			refs[i] = nd.Ref
+		}
+		w.packed(8, deltas(refs))
+		wayGroup.bytes(3, w)
+	}
+
+	var relationGroup message
+	memberTypes := map[string]uint64{"node": 0, "way": 1, "relation": 2}
+	for _, relation := range osm.Relations {
+		var r message
+		r.varint(1, uint64(relation.ID))
+		strings.tags(&r, relation.Tags)
+		roles := make([]uint64, len(relation.Members))
+		refs := make([]int64, len(relation.Members))
+		types := make([]uint64, len(relation.Members))
+		for i, member := range relation.Members {
+			roles[i], refs[i], types[i] = strings.id(member.Role), member.Ref, memberTypes[member.Type]
+		}
+		r.packed(8, roles)
+		r.packed(9, deltas(refs))
+		r.packed(10, types)
+		relationGroup.bytes(4, r)
+	}
+
+	var table message
+	for _, s := range strings.strings {
+		table.bytes(1, []byte(s))
+	}
+	var block message
+	block.bytes(1, table)
+	block.bytes(2, nodeGroup)
+	block.bytes(2, wayGroup)
+	block.bytes(2, relationGroup)
+	block.varint(17, 100)
+
+	var header message
+	header.bytes(4, []byte("OsmSchema-V0.6"))
+	header.bytes(4, []byte("DenseNodes"))
+
+	out := append(blob("OSMHeader", header), blob("OSMData", block)...)
+	if err := os.WriteFile("testdata/buildings.osm.pbf", out, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,100 @@
+package osm
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+)
+
+type xmlTag struct {
+	Key   string `xml:"k,attr"`
+	Value string `xml:"v,attr"`
+}
+
+type xmlNode struct {
+	ID  int64   `xml:"id,attr"`
+	Lat float64 `xml:"lat,attr"`
+	Lon float64 `xml:"lon,attr"`
+}
+
+type xmlWay struct {
+	ID   int64 `xml:"id,attr"`
+	Refs []struct {
+		Ref int64 `xml:"ref,attr"`
+	} `xml:"nd"`
+	Tags []xmlTag `xml:"tag"`
+}
+
+type xmlRelation struct {
+	ID      int64 `xml:"id,attr"`
+	Members []struct {
+		Type string `xml:"type,attr"`
+		Ref  int64  `xml:"ref,attr"`
+		Role string `xml:"role,attr"`
+	} `xml:"member"`
+	Tags []xmlTag `xml:"tag"`
+}
+
+func xmlTags(tags []xmlTag) map[string]string {
+	result := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		result[tag.Key] = tag.Value
+	}
+	return result
+}
+
+func scanXML(path string, v visitor) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := xml.NewDecoder(file)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", path, err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch {
+		case start.Name.Local == "node" && v.node != nil:
+			var node xmlNode
+			if err := decoder.DecodeElement(&node, &start); err != nil {
+				return fmt.Errorf("error reading node in %s: %w", path, err)
+			}
+			v.node(node.ID, node.Lat, node.Lon)
+		case start.Name.Local == "way" && v.way != nil:
+			var way xmlWay
+			if err := decoder.DecodeElement(&way, &start); err != nil {
+				return fmt.Errorf("error reading way in %s: %w", path, err)
+			}
+			refs := make([]int64, len(way.Refs))
+			for i, nd := range way.Refs {
+				refs[i] = nd.Ref
+			}
+			v.way(&Way{ID: way.ID, Refs: refs, Tags: xmlTags(way.Tags)})
+		case start.Name.Local == "relation" && v.relation != nil:
+			var relation xmlRelation
+			if err := decoder.DecodeElement(&relation, &start); err != nil {
+				return fmt.Errorf("error reading relation in %s: %w", path, err)
+			}
+			members := make([]Member, len(relation.Members))
+			for i, member := range relation.Members {
+				members[i] = Member{Type: member.Type, Ref: member.Ref, Role: member.Role}
+			}
+			v.relation(&Relation{ID: relation.ID, Members: members, Tags: xmlTags(relation.Tags)})
+		case start.Name.Local == "node" || start.Name.Local == "way" || start.Name.Local == "relation":
+			if err := decoder.Skip(); err != nil {
+				return fmt.Errorf("error reading %s: %w", path, err)
+			}
+		}
+	}
+}
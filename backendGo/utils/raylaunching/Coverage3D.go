@@ -0,0 +1,208 @@
+package raylaunching
+
+import (
+	"math"
+	"sort"
+)
+
+// defaultCoverageBinWidth is the bin width of CoverageOptions without
+// thresholds.
+const defaultCoverageBinWidth = 10.0
+
+// CoverageOptions select the levels CoverageStatistics summarises and the
+// power levels it reports coverage for: Thresholds, or multiples of BinWidth
+// (defaultCoverageBinWidth if 0) spanning the received powers. No ZLevels
+// means every level.
+type CoverageOptions struct {
+	Thresholds []float64
+	BinWidth   float64
+	ZLevels    []int
+}
+
+// ThresholdCoverage is the share of the outdoor voxels that receive at least
+// Threshold.
+type ThresholdCoverage struct {
+	Threshold float64 `json:"threshold"`
+	Percent   float64 `json:"percent"`
+}
+
+// HistogramBin counts the covered voxels with a power in [From, To). The
+// lowest and highest bins of thresholds are open and have no From or To.
+type HistogramBin struct {
+	From    *float64 `json:"from"`
+	To      *float64 `json:"to"`
+	Count   int      `json:"count"`
+	Percent float64  `json:"percent"` // of the covered voxels
+}
+
+// CDFPoint is the share of the covered voxels receiving at most Power.
+type CDFPoint struct {
+	Power       float64 `json:"power"`
+	Probability float64 `json:"probability"`
+}
+
+// LevelCoverage summarises the received power of the outdoor voxels of a
+// level (or of all requested levels, then Z is nil). Voxels counts the
+// outdoor voxels and Covered those a ray reached. The histogram, the CDF (with
+// a point per dB) and the percentiles are taken over the covered voxels,
+// the percentiles are nil when there are none. Powers are given in the unit
+// of the power map.
+type LevelCoverage struct {
+	Z              *int                `json:"z,omitempty"`
+	Voxels         int                 `json:"voxels"`
+	Covered        int                 `json:"covered"`
+	CoveredPercent float64             `json:"coveredPercent"`
+	Thresholds     []ThresholdCoverage `json:"thresholds"`
+	Histogram      []HistogramBin      `json:"histogram"`
+	CDF            []CDFPoint          `json:"cdf"`
+	Percentile5    *float64            `json:"p5"`
+	Median         *float64            `json:"median"`
+	Percentile95   *float64            `json:"p95"`
+}
+
+// CoverageReport holds the coverage of every requested level and of all of
+// them together.
+type CoverageReport struct {
+	Levels []LevelCoverage `json:"levels"`
+	Total  LevelCoverage   `json:"total"`
+}
+
+// CoverageStatistics summarises the outdoor voxels of powerMap: voxels that
+// hold a received power or NoSignal, without building interiors (marked by
+// indoorMap, nil when the run had no wall penetration) and geometry labels.
+func CoverageStatistics(powerMap [][][]float64, indoorMap [][][]bool, wallMapNumber int, options CoverageOptions) CoverageReport {
+	zLevels := options.ZLevels
+	if len(zLevels) == 0 {
+		for z := range powerMap {
+			zLevels = append(zLevels, z)
+		}
+	}
+
+	levels := make([][]float64, len(zLevels))
+	outdoor := make([]int, len(zLevels))
+	var all []float64
+	for i, z := range zLevels {
+		for y, row := range powerMap[z] {
+			for x, value := range row {
+				if int(value) >= wallMapNumber || (indoorMap != nil && indoorMap[z][y][x]) {
+					continue
+				}
+				outdoor[i]++
+				if value != NoSignal {
+					levels[i] = append(levels[i], value)
+				}
+			}
+		}
+		sort.Float64s(levels[i])
+		all = append(all, levels[i]...)
+	}
+	sort.Float64s(all)
+
+	edges, open := options.Thresholds, true
+	if len(edges) == 0 {
+		edges, open = binEdges(all, options.BinWidth), false
+	}
+	edges = append([]float64(nil), edges...)
+	sort.Float64s(edges)
+
+	report := CoverageReport{Levels: make([]LevelCoverage, len(zLevels))}
+	totalVoxels := 0
+	for i := range zLevels {
+		report.Levels[i] = levelCoverage(levels[i], outdoor[i], edges, open)
+		report.Levels[i].Z = &zLevels[i]
+		totalVoxels += outdoor[i]
+	}
+	report.Total = levelCoverage(all, totalVoxels, edges, open)
+	return report
+}
+
+// binEdges returns the multiples of width from below the lowest to above the
+// highest of the sorted values.
+func binEdges(values []float64, width float64) []float64 {
+	if width <= 0 {
+		width = defaultCoverageBinWidth
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	first := math.Floor(values[0] / width)
+	last := math.Floor(values[len(values)-1]/width) + 1
+	edges := make([]float64, 0, int(last-first)+1)
+	for k := first; k <= last; k++ {
+		edges = append(edges, k*width)
+	}
+	return edges
+}
+
+// atMost returns the number of sorted values that are at most limit.
+func atMost(values []float64, limit float64) int {
+	return sort.Search(len(values), func(i int) bool { return values[i] > limit })
+}
+
+// below returns the number of sorted values that are less than limit.
+func below(values []float64, limit float64) int {
+	return sort.SearchFloat64s(values, limit)
+}
+
+// percentile interpolates linearly between the closest ranks of the sorted
+// values.
+func percentile(values []float64, p float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	position := p / 100 * float64(len(values)-1)
+	lower := int(math.Floor(position))
+	upper := min(lower+1, len(values)-1)
+	value := values[lower] + (position-float64(lower))*(values[upper]-values[lower])
+	return &value
+}
+
+func levelCoverage(values []float64, voxels int, edges []float64, open bool) LevelCoverage {
+	level := LevelCoverage{
+		Voxels:       voxels,
+		Covered:      len(values),
+		Thresholds:   make([]ThresholdCoverage, len(edges)),
+		Histogram:    []HistogramBin{},
+		CDF:          []CDFPoint{},
+		Percentile5:  percentile(values, 5),
+		Median:       percentile(values, 50),
+		Percentile95: percentile(values, 95),
+	}
+	share := func(count, of int) float64 {
+		if of == 0 {
+			return 0
+		}
+		return float64(count) / float64(of) * 100
+	}
+	level.CoveredPercent = share(len(values), voxels)
+
+	for i, edge := range edges {
+		level.Thresholds[i] = ThresholdCoverage{Threshold: edge, Percent: share(len(values)-below(values, edge), voxels)}
+	}
+	addBin := func(from, to *float64) {
+		count := len(values)
+		if to != nil {
+			count = below(values, *to)
+		}
+		if from != nil {
+			count -= below(values, *from)
+		}
+		level.Histogram = append(level.Histogram, HistogramBin{From: from, To: to, Count: count, Percent: share(count, len(values))})
+	}
+	if open && len(edges) > 0 {
+		addBin(nil, &edges[0])
+	}
+	for i := 0; i+1 < len(edges); i++ {
+		addBin(&edges[i], &edges[i+1])
+	}
+	if open && len(edges) > 0 {
+		addBin(&edges[len(edges)-1], nil)
+	}
+
+	if len(values) > 0 {
+		for power := math.Floor(values[0]); power <= math.Ceil(values[len(values)-1]); power++ {
+			level.CDF = append(level.CDF, CDFPoint{Power: power, Probability: float64(atMost(values, power)) / float64(len(values))})
+		}
+	}
+	return level
+}
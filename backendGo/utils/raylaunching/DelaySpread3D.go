@@ -0,0 +1,119 @@
+package raylaunching
+
+import (
+	. "backendGo/types"
+	"math"
+	"sort"
+)
+
+// NoDelay marks voxels of the delay maps that no ray reaches.
+const NoDelay = -1.0
+
+// DelayTap is the power of the paths arriving at a receiver within one delay
+// bin of Config.DelayResolution ns.
+type DelayTap struct {
+	Delay float64 `json:"delay"` // ns after transmission, start of the bin
+	Power float64 `json:"power"` // in the unit of the power map
+}
+
+// PowerDelayProfile is the time dispersion at a receiver of Config.Receivers.
+// Delays are given in ns, MeanExcessDelay and RMSDelaySpread are taken from
+// the unbinned paths. A receiver no ray reaches has Power NoSignal, delays
+// NoDelay and no taps.
+type PowerDelayProfile struct {
+	Receiver        Point3D    `json:"receiver"`
+	Power           float64    `json:"power"`
+	FirstArrival    float64    `json:"firstArrival"`
+	MeanExcessDelay float64    `json:"meanExcessDelay"`
+	RMSDelaySpread  float64    `json:"rmsDelaySpread"`
+	Taps            []DelayTap `json:"taps"`
+}
+
+// delayMoments sums the linear power, power × delay and power × delay² of the
+// paths reaching a voxel, first is the earliest delay. Delays are in ns.
+type delayMoments struct {
+	power, powerDelay, powerDelay2, first float64
+}
+
+func (m *delayMoments) add(delay, power float64) {
+	if m.power == 0 || delay < m.first {
+		m.first = delay
+	}
+	m.power += power
+	m.powerDelay += power * delay
+	m.powerDelay2 += power * delay * delay
+}
+
+// stats returns the mean excess delay (the power weighted mean delay after the
+// first arrival) and the RMS delay spread, NoDelay if no path was added.
+func (m delayMoments) stats() (float64, float64) {
+	if m.power <= 0 {
+		return NoDelay, NoDelay
+	}
+	mean := m.powerDelay / m.power
+	variance := m.powerDelay2/m.power - mean*mean
+	return mean - m.first, math.Sqrt(math.Max(0, variance))
+}
+
+// pathDelay returns the delay in ns of a path at its voxel.
+func pathDelay(sample pathSample) float64 {
+	return sample.length / 299792458 * 1e9
+}
+
+// delayMaps returns the mean excess delay and RMS delay spread maps of the
+// voxels of powerMap that hold a received power, every path weighted once
+// with its power. Walls, roofs and corners the rays pass keep NoDelay like
+// they keep their labels in the power map.
+func delayMaps(paths *pathBuffer, powerMap [][][]float64, hasSignal func(value float64) bool) ([][][]float64, [][][]float64) {
+	sizeZ, sizeY, sizeX := len(powerMap), len(powerMap[0]), len(powerMap[0][0])
+	meanExcess := newFloatMatrix(sizeZ, sizeY, sizeX, NoDelay)
+	spread := newFloatMatrix(sizeZ, sizeY, sizeX, NoDelay)
+	paths.forEach(func(x, y, z int, samples []pathSample) {
+		if x >= sizeX || y >= sizeY || !hasSignal(powerMap[z][y][x]) {
+			return
+		}
+		var moments delayMoments
+		for _, sample := range samples {
+			moments.add(pathDelay(sample), sample.power)
+		}
+		meanExcess[z][y][x], spread[z][y][x] = moments.stats()
+	})
+	return meanExcess, spread
+}
+
+// powerDelayProfiles returns the profiles of receivers from the paths reaching
+// them, with taps of resolution ns (1 ns if 0) in order of delay.
+func powerDelayProfiles(paths *pathBuffer, receivers []Point3D, resolution float64) []PowerDelayProfile {
+	if resolution <= 0 {
+		resolution = 1
+	}
+	profiles := make([]PowerDelayProfile, len(receivers))
+	for i, receiver := range receivers {
+		var moments delayMoments
+		taps := make(map[int]float64)
+		for _, sample := range paths.voxel(int(math.Round(receiver.X)), int(math.Round(receiver.Y)), int(math.Round(receiver.Z))) {
+			delay := pathDelay(sample)
+			moments.add(delay, sample.power)
+			taps[int(math.Floor(delay/resolution))] += sample.power
+		}
+		profile := PowerDelayProfile{Receiver: receiver, Power: NoSignal, FirstArrival: NoDelay, Taps: []DelayTap{}}
+		profile.MeanExcessDelay, profile.RMSDelaySpread = moments.stats()
+		if moments.power > 0 {
+			profile.Power = 10 * math.Log10(moments.power)
+			profile.FirstArrival = moments.first
+		}
+		bins := make([]int, 0, len(taps))
+		for bin := range taps {
+			bins = append(bins, bin)
+		}
+		sort.Ints(bins)
+		for _, bin := range bins {
+			profile.Taps = append(profile.Taps, DelayTap{
+				Delay: float64(bin) * resolution,
+				Power: 10 * math.Log10(taps[bin]),
+			})
+		}
+		profiles[i] = profile
+	}
+	return profiles
+}
@@ -0,0 +1,81 @@
+package raylaunching
+
+import (
+	. "backendGo/types"
+	"math"
+)
+
+// DriveTestPoint is a measurement together with its voxel in the power map
+// and the power predicted there.
+type DriveTestPoint struct {
+	Measurement
+	X int `json:"x"`
+	Y int `json:"y"`
+	Z int `json:"z"`
+	// Predicted is nil when the voxel lies outside the map, inside a building
+	// or was not reached by any ray, or when the point has no measured power.
+	Predicted *float64 `json:"predicted"`
+	// Error is Predicted - Power, positive when the simulation overestimates.
+	Error *float64 `json:"error,omitempty"`
+}
+
+type DriveTestStats struct {
+	Count     int     `json:"count"`     // points with a prediction
+	Unmatched int     `json:"unmatched"` // points without a prediction
+	MeanError float64 `json:"meanError"`
+	RMSE      float64 `json:"rmse"`
+	StdDev    float64 `json:"stdDev"`
+	// Correlation is the Pearson coefficient of predicted and measured power,
+	// 0 when it is undefined (less than two points or no variance).
+	Correlation float64 `json:"correlation"`
+}
+
+// CompareDriveTest looks up the predicted power of every point in powerMap and
+// returns the error statistics over the points that have a prediction.
+func CompareDriveTest(powerMap [][][]float64, wallMapNumber int, points []DriveTestPoint) DriveTestStats {
+	var stats DriveTestStats
+	var sumError, sumPredicted, sumMeasured float64
+	for i := range points {
+		point := &points[i]
+		point.Predicted, point.Error = nil, nil
+		if point.Z < 0 || point.Z >= len(powerMap) || point.Y < 0 || point.Y >= len(powerMap[point.Z]) || point.X < 0 || point.X >= len(powerMap[point.Z][point.Y]) {
+			stats.Unmatched++
+			continue
+		}
+		predicted := powerMap[point.Z][point.Y][point.X]
+		if predicted == NoSignal || predicted >= float64(wallMapNumber) || point.Power == nil {
+			stats.Unmatched++
+			continue
+		}
+		diff := predicted - *point.Power
+		point.Predicted, point.Error = &predicted, &diff
+		stats.Count++
+		sumError += diff
+		sumPredicted += predicted
+		sumMeasured += *point.Power
+	}
+	if stats.Count == 0 {
+		return stats
+	}
+
+	n := float64(stats.Count)
+	stats.MeanError = sumError / n
+	meanPredicted, meanMeasured := sumPredicted/n, sumMeasured/n
+	var sumSquaredError, errorVariance, covariance, variancePredicted, varianceMeasured float64
+	for _, point := range points {
+		if point.Predicted == nil {
+			continue
+		}
+		sumSquaredError += *point.Error * *point.Error
+		errorVariance += (*point.Error - stats.MeanError) * (*point.Error - stats.MeanError)
+		covariance += (*point.Predicted - meanPredicted) * (*point.Power - meanMeasured)
+		variancePredicted += (*point.Predicted - meanPredicted) * (*point.Predicted - meanPredicted)
+		varianceMeasured += (*point.Power - meanMeasured) * (*point.Power - meanMeasured)
+	}
+	stats.RMSE = math.Sqrt(sumSquaredError / n)
+	stats.StdDev = math.Sqrt(errorVariance / n)
+	if stats.Count > 1 && variancePredicted > 0 && varianceMeasured > 0 {
+		stats.Correlation = math.Max(-1, math.Min(1, covariance/math.Sqrt(variancePredicted*varianceMeasured)))
+	}
+	return stats
+}
@@ -0,0 +1,34 @@
+package raylaunching
+
+import (
+	. "backendGo/types"
+	"math"
+	"testing"
+)
+
+func TestCompareDriveTest(t *testing.T) {
+	powerMap := [][][]float64{{{-60, -80, NoSignal, 1000}}}
+	power := func(dBm float64) *float64 { return &dBm }
+	points := []DriveTestPoint{
+		{Measurement: Measurement{Power: power(-62)}, X: 0},
+		{Measurement: Measurement{Power: power(-76)}, X: 1},
+		{Measurement: Measurement{}, X: 1},                  // no measured power
+		{Measurement: Measurement{Power: power(-90)}, X: 2}, // not reached
+		{Measurement: Measurement{Power: power(-90)}, X: 3}, // wall
+		{Measurement: Measurement{Power: power(-90)}, X: 4}, // outside the map
+	}
+	stats := CompareDriveTest(powerMap, 1000, points)
+	if stats.Count != 2 || stats.Unmatched != 4 {
+		t.Fatalf("count %d, unmatched %d, want 2 and 4", stats.Count, stats.Unmatched)
+	}
+	// errors +2 and -4 dB
+	if math.Abs(stats.MeanError+1) > 1e-9 || math.Abs(stats.RMSE-math.Sqrt(10)) > 1e-9 || math.Abs(stats.StdDev-3) > 1e-9 {
+		t.Errorf("mean error %g, RMSE %g, std dev %g, want -1, √10, 3", stats.MeanError, stats.RMSE, stats.StdDev)
+	}
+	if math.Abs(stats.Correlation-1) > 1e-9 {
+		t.Errorf("correlation %g, want 1", stats.Correlation)
+	}
+	if points[2].Predicted != nil {
+		t.Errorf("point without power has prediction %g", *points[2].Predicted)
+	}
+}
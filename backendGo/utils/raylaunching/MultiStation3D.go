@@ -0,0 +1,163 @@
+package raylaunching
+
+import (
+	. "backendGo/types"
+	"context"
+	"math"
+)
+
+// NoSignal marks voxels of the combined maps that no station reaches (and
+// voxels occupied by walls, roofs or unreached building interiors).
+const NoSignal = -160.0
+
+type Station struct {
+	Pos       Point3D
+	Power     float64  // watt
+	Frequency float64  // Hz
+	Antenna   *Antenna // nil means isotropic
+}
+
+type MultiStationResult struct {
+	// PowerMap has the same layout as RayLaunching3D.PowerMap (geometry labels
+	// included) and holds the strongest power of any station per voxel.
+	PowerMap       [][][]float64
+	PowerMapLegend map[int]PowerMapLegendEntry
+	// BestServerMap holds the index of the strongest station per voxel, -1 if
+	// no station reaches the voxel.
+	BestServerMap [][][]int
+	MaxRSRPMap    [][][]float64
+	// SINRMap is the power of the best server over the summed power of all
+	// other stations on the same frequency plus the noise floor, in dB.
+	SINRMap  [][][]float64
+	Stations []*RayLaunching3D
+}
+
+func copyMatrix(matrix [][][]float64) [][][]float64 {
+	copied := make([][][]float64, len(matrix))
+	for z := range matrix {
+		copied[z] = make([][]float64, len(matrix[z]))
+		for y := range matrix[z] {
+			copied[z][y] = append([]float64(nil), matrix[z][y]...)
+		}
+	}
+	return copied
+}
+
+func newIntMatrix(sizeZ, sizeY, sizeX int, value int) [][][]int {
+	matrix := make([][][]int, sizeZ)
+	for z := range matrix {
+		matrix[z] = make([][]int, sizeY)
+		for y := range matrix[z] {
+			matrix[z][y] = make([]int, sizeX)
+			for x := range matrix[z][y] {
+				matrix[z][y][x] = value
+			}
+		}
+	}
+	return matrix
+}
+
+func newFloatMatrix(sizeZ, sizeY, sizeX int, value float64) [][][]float64 {
+	matrix := make([][][]float64, sizeZ)
+	for z := range matrix {
+		matrix[z] = make([][]float64, sizeY)
+		for y := range matrix[z] {
+			matrix[z][y] = make([]float64, sizeX)
+			for x := range matrix[z][y] {
+				matrix[z][y][x] = value
+			}
+		}
+	}
+	return matrix
+}
+
+// hasSignal reports whether a PowerMap value is a received power rather than
+// a geometry label or an empty voxel.
+func (rl *RayLaunching3D) hasSignal(value float64) bool {
+	return value != NoSignal && int(value) < rl.Config.WallMapNumber
+}
+
+// CalculateMultiStation3D runs a separate propagation pass for every station
+// over the same geometry and combines the results. Every station uses config
+// with TransmitterPos, TransmitterPower, TransmitterFreq, WaveLength and
+// Antenna replaced by its own values. noiseFloor is given in the unit of the power map.
+func CalculateMultiStation3D(ctx context.Context, matrix [][][]float64, wallNormals []Normal3D, config RayLaunching3DConfig, stations []Station, noiseFloor float64, progress func(done, total int)) (*MultiStationResult, error) {
+	result := &MultiStationResult{Stations: make([]*RayLaunching3D, len(stations))}
+	for s, station := range stations {
+		stationConfig := config
+		stationConfig.TransmitterPos = station.Pos
+		stationConfig.TransmitterPower = station.Power
+		stationConfig.TransmitterFreq = station.Frequency
+		stationConfig.WaveLength = 299792458 / station.Frequency
+		stationConfig.Antenna = station.Antenna
+
+		rayLaunching := NewRayLaunching3D(copyMatrix(matrix), wallNormals, stationConfig)
+		var stationProgress func(done, total int)
+		if progress != nil {
+			stationProgress = func(done, total int) {
+				progress(s*total+done, len(stations)*total)
+			}
+		}
+		if err := rayLaunching.CalculateRayLaunching3DContext(ctx, stationProgress); err != nil {
+			return nil, err
+		}
+		result.Stations[s] = rayLaunching
+	}
+	if len(stations) == 0 {
+		return result, nil
+	}
+	result.combine(stations, noiseFloor)
+	return result, nil
+}
+
+func (result *MultiStationResult) combine(stations []Station, noiseFloor float64) {
+	first := result.Stations[0]
+	sizeZ, sizeY, sizeX := len(first.PowerMap), len(first.PowerMap[0]), len(first.PowerMap[0][0])
+	result.BestServerMap = newIntMatrix(sizeZ, sizeY, sizeX, -1)
+	result.MaxRSRPMap = newFloatMatrix(sizeZ, sizeY, sizeX, NoSignal)
+	result.SINRMap = newFloatMatrix(sizeZ, sizeY, sizeX, NoSignal)
+	if len(result.Stations) == 1 {
+		result.PowerMap = first.PowerMap
+	} else {
+		result.PowerMap = copyMatrix(first.PowerMap)
+	}
+	noiseLinear := math.Pow(10, noiseFloor/10)
+	linear := make([]float64, len(result.Stations))
+
+	for z := 0; z < sizeZ; z++ {
+		for y := 0; y < sizeY; y++ {
+			for x := 0; x < sizeX; x++ {
+				best := -1
+				for s, station := range result.Stations {
+					power := station.PowerMap[z][y][x]
+					if !station.hasSignal(power) {
+						linear[s] = 0
+						continue
+					}
+					linear[s] = math.Pow(10, power/10)
+					if best < 0 || power > result.Stations[best].PowerMap[z][y][x] {
+						best = s
+					}
+				}
+				if best < 0 {
+					continue
+				}
+				bestPower := result.Stations[best].PowerMap[z][y][x]
+				interference := noiseLinear
+				for s := range result.Stations {
+					if s != best && stations[s].Frequency == stations[best].Frequency {
+						interference += linear[s]
+					}
+				}
+				result.BestServerMap[z][y][x] = best
+				result.MaxRSRPMap[z][y][x] = bestPower
+				result.SINRMap[z][y][x] = bestPower - 10*math.Log10(interference)
+				result.PowerMap[z][y][x] = bestPower
+			}
+		}
+	}
+
+	combined := &RayLaunching3D{PowerMap: result.PowerMap, Config: first.Config, IndoorMap: first.IndoorMap}
+	combined.CreatePowerMapLegend()
+	result.PowerMapLegend = combined.PowerMapLegend
+}
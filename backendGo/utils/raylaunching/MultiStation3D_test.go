@@ -0,0 +1,83 @@
+package raylaunching
+
+import (
+	. "backendGo/types"
+	"context"
+	"math"
+	"testing"
+)
+
+func TestCombineStations(t *testing.T) {
+	config := RayLaunching3DConfig{WallMapNumber: 1000}
+	result := &MultiStationResult{Stations: []*RayLaunching3D{
+		{Config: config, PowerMap: [][][]float64{{{-60, -80, NoSignal, 1000}}}},
+		{Config: config, PowerMap: [][][]float64{{{-70, -70, -90, 1000}}}},
+	}}
+	stations := []Station{{Frequency: 2.4e9}, {Frequency: 2.4e9}}
+	result.combine(stations, -100)
+
+	// SINR of best over other + noise, all in dBm
+	sinr := func(best float64, others ...float64) float64 {
+		interference := math.Pow(10, -100.0/10)
+		for _, other := range others {
+			interference += math.Pow(10, other/10)
+		}
+		return best - 10*math.Log10(interference)
+	}
+	for _, test := range []struct {
+		x          int
+		bestServer int
+		power      float64
+		sinr       float64
+	}{
+		{0, 0, -60, sinr(-60, -70)},
+		{1, 1, -70, sinr(-70, -80)},
+		{2, 1, -90, 10},
+		{3, -1, NoSignal, NoSignal},
+	} {
+		if got := result.BestServerMap[0][0][test.x]; got != test.bestServer {
+			t.Errorf("voxel %d: best server %d, want %d", test.x, got, test.bestServer)
+		}
+		if got := result.MaxRSRPMap[0][0][test.x]; got != test.power {
+			t.Errorf("voxel %d: max RSRP %g dBm, want %g dBm", test.x, got, test.power)
+		}
+		if got := result.SINRMap[0][0][test.x]; math.Abs(got-test.sinr) > 1e-9 {
+			t.Errorf("voxel %d: SINR %.4f dB, want %.4f dB", test.x, got, test.sinr)
+		}
+	}
+	if result.PowerMap[0][0][3] != 1000 {
+		t.Errorf("the wall voxel became %g in the combined power map", result.PowerMap[0][0][3])
+	}
+
+	// a station on another frequency does not interfere
+	stations[1].Frequency = 3.5e9
+	result.combine(stations, -100)
+	if got := result.SINRMap[0][0][0]; math.Abs(got-40) > 1e-9 {
+		t.Errorf("SINR without co-channel interference %.4f dB, want 40 dB", got)
+	}
+}
+
+func TestCalculateMultiStation3D(t *testing.T) {
+	matrix, normals := testMap(40, 40, 8, -1)
+	config := testConfig(40, 40, 8, Point3D{})
+	stations := []Station{
+		{Pos: Point3D{X: 8, Y: 20, Z: 3}, Power: 1, Frequency: 2.4e9},
+		{Pos: Point3D{X: 32, Y: 20, Z: 3}, Power: 1, Frequency: 2.4e9},
+	}
+	result, err := CalculateMultiStation3D(context.Background(), matrix, normals, config, stations, -100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct{ x, bestServer int }{{4, 0}, {12, 0}, {28, 1}, {36, 1}} {
+		if got := result.BestServerMap[3][20][test.x]; got != test.bestServer {
+			t.Errorf("voxel (%d, 20, 3): best server %d, want %d", test.x, got, test.bestServer)
+		}
+		if sinr := result.SINRMap[3][20][test.x]; sinr <= 0 {
+			t.Errorf("voxel (%d, 20, 3): SINR %.2f dB next to its best server", test.x, sinr)
+		}
+	}
+	// halfway between the stations both are received at about the same power
+	if sinr := result.SINRMap[3][20][20]; math.Abs(sinr) > 1 {
+		t.Errorf("SINR halfway between the stations %.2f dB, want about 0 dB", sinr)
+	}
+}
@@ -0,0 +1,227 @@
+package raylaunching
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// Aggregation modes of RayLaunching3DConfig, they decide how the rays that
+// reach a voxel are combined.
+const (
+	// AggregationMax keeps the power of the strongest ray.
+	AggregationMax = "max"
+	// AggregationIncoherent adds the powers of all paths.
+	AggregationIncoherent = "incoherent"
+	// AggregationCoherent adds the complex fields of all paths, so that paths
+	// interfere and the power map shows small-scale fading.
+	AggregationCoherent = "coherent"
+)
+
+func (rl *RayLaunching3D) aggregation() string {
+	if rl.Config.Aggregation == "" {
+		return AggregationMax
+	}
+	return rl.Config.Aggregation
+}
+
+// voxelCentreRayLength returns the length of the ray's path up to the point
+// nearest to the centre of voxel (xIdx, yIdx, zIdx). The phase of a path is
+// taken there, so that all rays of the path reach the voxel in phase.
+func (rl *RayLaunching3D) voxelCentreRayLength(state *RayState, xIdx, yIdx, zIdx int) float64 {
+	cellX, cellY, levelHeight := rl.voxelSize()
+	dx, dy, dz := state.dx*cellX, state.dy*cellY, state.dz*levelHeight
+	length := math.Sqrt(dx*dx + dy*dy + dz*dz)
+	if length == 0 {
+		return state.currRayLength
+	}
+	step := rl.Config.Step
+	ox := (float64(xIdx)*step - state.x) * cellX
+	oy := (float64(yIdx)*step - state.y) * cellY
+	oz := (float64(zIdx)*step - state.z) * levelHeight
+	return state.currRayLength + (ox*dx+oy*dy+oz*dz)/length
+}
+
+// pathOrigin identifies the path of a ray before its first interaction, the
+// offset basis of 64-bit FNV-1a.
+const pathOrigin uint64 = 14695981039346656037
+
+// extendPath adds an interaction of the ray at its position to the identity
+// of its path. Rays that interact with the same walls, roofs, corners and the
+// ground in the same order follow the same path, whichever direction they
+// were launched in, so that a path counts once however many of its rays
+// reach a voxel. Diffractions are told apart by the corner's cell.
+func (rl *RayLaunching3D) extendPath(state *RayState, vertex RayVertex) {
+	const prime = 1099511628211
+	path := state.path
+	for i := 0; i < len(vertex.Type); i++ {
+		path = (path ^ uint64(vertex.Type[i])) * prime
+	}
+	values := []int{vertex.Wall, vertex.Building}
+	if vertex.Type == InteractionDiffraction {
+		xIdx, yIdx, _ := rl.getMapIndices(state.x, state.y, state.z)
+		values = append(values, xIdx, yIdx)
+	}
+	for _, value := range values {
+		path = (path ^ uint64(value)) * prime
+	}
+	state.path = path
+}
+
+// interact records an interaction of the ray at its position in its path and,
+// for target rays, in its branch.
+func (rl *RayLaunching3D) interact(state *RayState, vertex RayVertex) {
+	rl.extendPath(state, vertex)
+	rl.addVertex(state, vertex)
+}
+
+// pathSample is the strongest sample of a path in a voxel: its power in W and
+// the length in metres of the path up to the voxel centre.
+type pathSample struct {
+	path          uint64
+	power, length float64
+	next          int32 // index of the voxel's previous path in the tile, -1 for none
+}
+
+// pathTile holds the paths of powerTileSize x powerTileSize voxels of a
+// level, head gives the index of the last path of every voxel in samples.
+type pathTile struct {
+	head    []int32
+	samples []pathSample
+}
+
+// pathBuffer collects the paths reaching every voxel (or only the voxels of
+// only, when it is not nil) in the rays traced by one worker, in tiles
+// allocated on first write like powerBuffer. Each path keeps its strongest
+// sample, so that it adds to the sums of a voxel once, with its full power.
+type pathBuffer struct {
+	tilesX, tilesY int
+	tiles          []*pathTile
+	only           map[[3]int][]int
+}
+
+// newPathBuffer returns the path buffer of a worker, nil if neither the
+// aggregation, the delay maps nor the receivers need the paths.
+func (rl *RayLaunching3D) newPathBuffer(sizeX, sizeY, sizeZ int) *pathBuffer {
+	var only map[[3]int][]int
+	if rl.aggregation() == AggregationMax && !rl.Config.DelaySpread {
+		if len(rl.Config.Receivers) == 0 {
+			return nil
+		}
+		only = receiverVoxels(rl.Config.Receivers)
+	}
+	tilesX := (sizeX + powerTileSize - 1) / powerTileSize
+	tilesY := (sizeY + powerTileSize - 1) / powerTileSize
+	return &pathBuffer{
+		tilesX: tilesX,
+		tilesY: tilesY,
+		tiles:  make([]*pathTile, tilesX*tilesY*sizeZ),
+		only:   only,
+	}
+}
+
+func (t *pathTile) add(k int, sample pathSample) {
+	for i := t.head[k]; i >= 0; i = t.samples[i].next {
+		if t.samples[i].path == sample.path {
+			if sample.power > t.samples[i].power {
+				t.samples[i].power, t.samples[i].length = sample.power, sample.length
+			}
+			return
+		}
+	}
+	sample.next = t.head[k]
+	t.samples = append(t.samples, sample)
+	t.head[k] = int32(len(t.samples) - 1)
+}
+
+// add records a sample of a path at voxel (x, y, z).
+func (b *pathBuffer) add(x, y, z int, sample pathSample) {
+	if b.only != nil {
+		if _, ok := b.only[[3]int{x, y, z}]; !ok {
+			return
+		}
+	}
+	tileIndex := (z*b.tilesY+y/powerTileSize)*b.tilesX + x/powerTileSize
+	tile := b.tiles[tileIndex]
+	if tile == nil {
+		tile = &pathTile{head: make([]int32, powerTileSize*powerTileSize)}
+		for k := range tile.head {
+			tile.head[k] = -1
+		}
+		b.tiles[tileIndex] = tile
+	}
+	tile.add((y%powerTileSize)*powerTileSize+x%powerTileSize, sample)
+}
+
+// merge adds the paths of other to b, a path both traced keeps the stronger
+// sample.
+func (b *pathBuffer) merge(other *pathBuffer) {
+	for tileIndex, tile := range other.tiles {
+		if tile == nil {
+			continue
+		}
+		if b.tiles[tileIndex] == nil {
+			b.tiles[tileIndex] = tile
+			continue
+		}
+		for k, head := range tile.head {
+			for i := head; i >= 0; i = tile.samples[i].next {
+				b.tiles[tileIndex].add(k, tile.samples[i])
+			}
+		}
+	}
+}
+
+// voxel returns the paths reaching voxel (x, y, z).
+func (b *pathBuffer) voxel(x, y, z int) []pathSample {
+	tile := b.tiles[(z*b.tilesY+y/powerTileSize)*b.tilesX+x/powerTileSize]
+	if tile == nil {
+		return nil
+	}
+	var samples []pathSample
+	for i := tile.head[(y%powerTileSize)*powerTileSize+x%powerTileSize]; i >= 0; i = tile.samples[i].next {
+		samples = append(samples, tile.samples[i])
+	}
+	return samples
+}
+
+// forEach calls fn with the paths of every voxel some path reaches. samples is
+// only valid during the call.
+func (b *pathBuffer) forEach(fn func(x, y, z int, samples []pathSample)) {
+	var samples []pathSample
+	for tileIndex, tile := range b.tiles {
+		if tile == nil {
+			continue
+		}
+		z := tileIndex / (b.tilesX * b.tilesY)
+		tileY := (tileIndex / b.tilesX) % b.tilesY
+		tileX := tileIndex % b.tilesX
+		for k, head := range tile.head {
+			samples = samples[:0]
+			for i := head; i >= 0; i = tile.samples[i].next {
+				samples = append(samples, tile.samples[i])
+			}
+			if len(samples) > 0 {
+				fn(tileX*powerTileSize+k%powerTileSize, tileY*powerTileSize+k/powerTileSize, z, samples)
+			}
+		}
+	}
+}
+
+// pathSum returns the power in W of the paths reaching a voxel, the sum of
+// their powers (AggregationIncoherent) or the power of the sum of their
+// fields (AggregationCoherent). The phase of a path is the one
+// calculateTransmittance gives for its length.
+func (rl *RayLaunching3D) pathSum(samples []pathSample) float64 {
+	if rl.aggregation() == AggregationIncoherent {
+		power := 0.0
+		for _, sample := range samples {
+			power += sample.power
+		}
+		return power
+	}
+	var field complex128
+	for _, sample := range samples {
+		field += cmplx.Rect(math.Sqrt(sample.power), -2*math.Pi*sample.length/rl.Config.WaveLength)
+	}
+	return real(field)*real(field) + imag(field)*imag(field)
+}
@@ -0,0 +1,104 @@
+package raylaunching
+
+import (
+	. "backendGo/types"
+	"math"
+	"testing"
+)
+
+// testMap returns an empty map of sizeX x sizeY x sizeZ voxels with one wall
+// (wall 0, facing -x) at x = wallX, for y from 5 to sizeY-6 and every level.
+func testMap(sizeX, sizeY, sizeZ, wallX int) ([][][]float64, []Normal3D) {
+	matrix := make([][][]float64, sizeZ)
+	for z := range matrix {
+		matrix[z] = make([][]float64, sizeY)
+		for y := range matrix[z] {
+			matrix[z][y] = make([]float64, sizeX)
+			for x := range matrix[z][y] {
+				matrix[z][y][x] = -160
+				if x == wallX && y >= 5 && y < sizeY-5 {
+					matrix[z][y][x] = 1000
+				}
+			}
+		}
+	}
+	return matrix, []Normal3D{{Nx: -1}}
+}
+
+// testConfig returns the configuration of a 2.4 GHz transmitter at pos on a
+// map of sizeX x sizeY x sizeZ voxels.
+func testConfig(sizeX, sizeY, sizeZ int, pos Point3D) RayLaunching3DConfig {
+	config := RayLaunching3DConfig{
+		NumOfRaysAzim: 360, NumOfRaysElev: 90, NumOfInteractions: 3,
+		WallMapNumber: 1000, RoofMapNumber: 5000, CornerMapNumber: 10000, RoofCornerMapNumber: 10001, BuldingInteriorNumber: 20000,
+		SizeX: float64(sizeX - 1), SizeY: float64(sizeY - 1), SizeZ: float64(sizeZ - 1),
+		Step: 1, ReflFactor: 0.5, TransmitterPower: 10, MinimalRayPower: -130, TransmitterFreq: 2.4e9,
+		TransmitterPos: pos, DiffractionRayNumber: 10,
+	}
+	config.WaveLength = 299792458 / config.TransmitterFreq
+	return config
+}
+
+func runTestMap(aggregation string) [][][]float64 {
+	matrix, normals := testMap(40, 40, 8, 30)
+	config := testConfig(40, 40, 8, Point3D{X: 10, Y: 20, Z: 3})
+	config.Aggregation = aggregation
+	rl := NewRayLaunching3D(matrix, normals, config)
+	rl.CalculateRayLaunching3D()
+	return rl.PowerMap
+}
+
+func TestIncoherentSumIsNotBelowMax(t *testing.T) {
+	maxMap := runTestMap(AggregationMax)
+	sumMap := runTestMap(AggregationIncoherent)
+	reached, above := 0, 0
+	for z := range maxMap {
+		for y := range maxMap[z] {
+			for x, power := range maxMap[z][y] {
+				if power == -160 || power >= 1000 {
+					continue
+				}
+				reached++
+				sum := sumMap[z][y][x]
+				if sum < power-1e-9 {
+					t.Fatalf("voxel (%d, %d, %d): incoherent %.2f dBm below max %.2f dBm", x, y, z, sum, power)
+				}
+				if sum > power+0.1 {
+					above++
+				}
+			}
+		}
+	}
+	if reached == 0 || above == 0 {
+		t.Errorf("%d voxels reached, %d with more than one path, want both > 0", reached, above)
+	}
+}
+
+func TestPathSum(t *testing.T) {
+	const power = 1e-3
+	for _, test := range []struct {
+		aggregation string
+		lengthDiff  float64 // in wavelengths
+		wantdB      float64 // over the power of one path
+	}{
+		{AggregationIncoherent, 0, 10 * math.Log10(2)},
+		{AggregationIncoherent, 0.5, 10 * math.Log10(2)},
+		{AggregationCoherent, 1, 20 * math.Log10(2)},
+		{AggregationCoherent, 0.25, 10 * math.Log10(2)},
+		{AggregationCoherent, 0.5, math.Inf(-1)},
+	} {
+		rl := &RayLaunching3D{Config: RayLaunching3DConfig{Aggregation: test.aggregation, WaveLength: 0.125}}
+		samples := []pathSample{
+			{path: 1, power: power, length: 10},
+			{path: 2, power: power, length: 10 + test.lengthDiff*rl.Config.WaveLength},
+		}
+		got := 10 * math.Log10(rl.pathSum(samples)/power)
+		if math.IsInf(test.wantdB, -1) {
+			if got > -100 {
+				t.Errorf("%s, %.2f λ apart: %.2f dB, want the paths to cancel", test.aggregation, test.lengthDiff, got)
+			}
+		} else if math.Abs(got-test.wantdB) > 1e-6 {
+			t.Errorf("%s, %.2f λ apart: %.4f dB, want %.4f dB", test.aggregation, test.lengthDiff, got, test.wantdB)
+		}
+	}
+}
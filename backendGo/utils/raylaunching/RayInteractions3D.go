@@ -0,0 +1,97 @@
+package raylaunching
+
+import "math"
+
+// Types of RayVertex.
+const (
+	InteractionLaunch           = "launch"
+	InteractionWallReflection   = "wallReflection"
+	InteractionRoofReflection   = "roofReflection"
+	InteractionGroundReflection = "groundReflection"
+	InteractionDiffraction      = "diffraction"
+	InteractionTransmission     = "transmission"
+	InteractionTermination      = "termination"
+)
+
+// RayVertex is a point where a target ray (see RayLaunching3DConfig.SingleRays)
+// interacts with the map. X, Y and Z are in voxels like RayPoint, Length is
+// the length in metres of the path from the transmitter and Power the power
+// of the ray right after the interaction.
+type RayVertex struct {
+	Type   string  `json:"type"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Z      float64 `json:"z"`
+	Power  float64 `json:"power"`
+	Length float64 `json:"length"`
+	// Wall is the index of the wall into the wall normals and Building the
+	// index of the building into MapMaterials.Buildings, -1 if none is
+	// involved.
+	Wall         int    `json:"wall"`
+	Building     int    `json:"building"`
+	BuildingName string `json:"buildingName,omitempty"`
+	// IncidenceAngle is the angle in degrees between a reflected ray and the
+	// normal of the surface, ReflectionFactor the factor the amplitude of the
+	// ray is multiplied with. Interactions other than reflections have a
+	// ReflectionFactor of 1.
+	IncidenceAngle   float64 `json:"incidenceAngle"`
+	ReflectionFactor float64 `json:"reflectionFactor"`
+	// DiffractionAngle is the angle in degrees between the first vertex of a
+	// diffraction fan's branch and the diffracted ray, DiffractionLoss the
+	// diffraction loss in dB the ray has at the vertex.
+	DiffractionAngle float64 `json:"diffractionAngle"`
+	DiffractionLoss  float64 `json:"diffractionLoss"`
+	// PenetrationLoss is the loss in dB of the wall, corner or roof a
+	// transmission crosses.
+	PenetrationLoss float64 `json:"penetrationLoss"`
+}
+
+// RayBranch is the part of a target ray between two interactions that start
+// or end a ray: it starts with a launch, diffraction or transmission and ends
+// with a diffraction or termination. The rays of a diffraction fan and the
+// rays transmitted through walls are branches of their own.
+type RayBranch []RayVertex
+
+// interaction returns the vertex of an interaction of the given type with
+// wall and building (-1 if none is involved).
+func interaction(vertexType string, wall, building int) RayVertex {
+	return RayVertex{Type: vertexType, Wall: wall, Building: building, ReflectionFactor: 1}
+}
+
+// reflection returns the vertex of a reflection at angle theta (in radians)
+// that multiplied the ray's amplitude with factor.
+func reflection(vertexType string, wall, building int, theta, factor float64) RayVertex {
+	vertex := interaction(vertexType, wall, building)
+	vertex.IncidenceAngle = theta * 180 / math.Pi
+	vertex.ReflectionFactor = factor
+	return vertex
+}
+
+// startBranch starts a new branch of the target ray of state at its position.
+func (rl *RayLaunching3D) startBranch(state *RayState, vertex RayVertex) {
+	if state.targetRayIndex < 0 {
+		return
+	}
+	rl.RayBranches[state.targetRayIndex] = append(rl.RayBranches[state.targetRayIndex], nil)
+	state.branch = len(rl.RayBranches[state.targetRayIndex]) - 1
+	rl.addVertex(state, vertex)
+}
+
+// addVertex adds vertex at the position of state to its branch, with the
+// power, path length and diffraction loss of the ray there.
+func (rl *RayLaunching3D) addVertex(state *RayState, vertex RayVertex) {
+	if state.targetRayIndex < 0 {
+		return
+	}
+	vertex.X, vertex.Y, vertex.Z = state.x/rl.Config.Step, state.y/rl.Config.Step, state.z/rl.Config.Step
+	vertex.BuildingName = rl.Config.Materials.buildingName(vertex.Building)
+	if vertex.Type == InteractionLaunch {
+		pos := rl.Config.TransmitterPos
+		vertex.X, vertex.Y, vertex.Z = pos.X/rl.Config.Step, pos.Y/rl.Config.Step, pos.Z/rl.Config.Step
+		vertex.Power = 10*math.Log10(rl.transmitterPower()) + state.antennaGaindB
+	} else {
+		vertex.Length, vertex.DiffractionLoss, vertex.Power = rl.rayPower(state)
+	}
+	branch := &rl.RayBranches[state.targetRayIndex][state.branch]
+	*branch = append(*branch, vertex)
+}
@@ -2,6 +2,7 @@ package raylaunching
 
 import (
 	. "backendGo/types"
+	"context"
 	"fmt"
 	"math"
 	"math/cmplx"
@@ -12,6 +13,33 @@ type RayLaunching3DConfig struct {
 	SizeX, SizeY, SizeZ, Step, ReflFactor, TransmitterPower, MinimalRayPower, TransmitterFreq, WaveLength                                                            float64
 	TransmitterPos                                                                                                                                                   Point3D
 	SingleRays                                                                                                                                                       []SingleRay
+
+	// NumOfWorkers is the number of goroutines rays are split across, 0 means runtime.NumCPU().
+	NumOfWorkers int
+	// Materials assigns reflection materials to walls and roofs, nil means concrete everywhere.
+	Materials *MapMaterials
+	// WallPenetration lets rays cross walls into buildings (with the penetration
+	// loss of the wall material) instead of stopping at building interiors.
+	WallPenetration bool
+	// Antenna weights every launched ray with the antenna gain in its
+	// direction, nil means an isotropic radiator.
+	Antenna *Antenna
+	// CellSize is the width in metres of a horizontal cell of the map along
+	// x, CellSizeY along y where it differs (legacy linear grids, 0 means
+	// CellSize) and LevelHeight the height in metres of a level. 0 means 1 m.
+	CellSize, CellSizeY, LevelHeight float64
+	// Aggregation combines the rays reaching a voxel, one of AggregationMax
+	// (the default), AggregationIncoherent or AggregationCoherent. The sums
+	// count every path (see extendPath) once, with its strongest ray.
+	Aggregation string
+	// DelaySpread records the delays of the paths reaching every voxel for the
+	// mean excess delay and RMS delay spread maps.
+	DelaySpread bool
+	// Receivers are the voxels power delay profiles and the angles of arrival
+	// and departure are recorded for, with bins of DelayResolution ns (0
+	// means 1 ns) and AngleResolution degrees (0 means 5°).
+	Receivers                        []Point3D
+	DelayResolution, AngleResolution float64
 }
 
 type RayPoint struct {
@@ -25,7 +53,27 @@ type RayLaunching3D struct {
 	WallNormals    []Normal3D
 	Config         RayLaunching3DConfig
 	RayPaths       [][]RayPoint
+	RayBranches    [][]RayBranch // interactions of the target rays, indexed like RayPaths
 	PowerMapLegend map[int]PowerMapLegendEntry
+	// IndoorMap marks the building interior voxels, it is only set when
+	// Config.WallPenetration is enabled.
+	IndoorMap [][][]bool
+	// MeanExcessDelayMap and RMSDelaySpreadMap hold the delay statistics in ns
+	// per voxel (NoDelay where no ray arrives) when Config.DelaySpread is
+	// enabled. PowerDelayProfiles are the profiles of Config.Receivers.
+	MeanExcessDelayMap, RMSDelaySpreadMap [][][]float64
+	PowerDelayProfiles                    []PowerDelayProfile
+	ReceiverAngles                        []ReceiverAngles
+
+	// buffer collects the power of the rays traced by one worker. While rays
+	// are traced PowerMap is only read (for the geometry labels).
+	buffer *powerBuffer
+	// paths collects the paths of the worker's rays for the incoherent and
+	// coherent sums, the delays and the receivers, nil when none is needed.
+	paths *pathBuffer
+	// angles collects the directions of the worker's rays at the receivers,
+	// nil without receivers.
+	angles *angleBuffer
 }
 
 type PowerMapLegendEntry struct {
@@ -38,6 +86,11 @@ type PowerMapLegendEntry struct {
 	P100_120 float64 `json:"< -100dbm"`
 	P120_140 float64 `json:"< -120dbm"`
 	P140plus float64 `json:"< -140dbm"`
+
+	// Indoor and Outdoor split the entry into building interiors and open
+	// air, they are only set when wall penetration is enabled.
+	Indoor  *PowerMapLegendEntry `json:"indoor,omitempty"`
+	Outdoor *PowerMapLegendEntry `json:"outdoor,omitempty"`
 }
 
 type RayState struct {
@@ -55,6 +108,11 @@ type RayState struct {
 	toDiffractionPointRayLength float64
 	diffTheta                   float64
 	diffRayIndex                int
+	penetrationLossdB           float64
+	antennaGaindB               float64
+	branch                      int     // index into RayBranches of the target ray
+	launchDir                   Point3D // direction the ray was launched in
+	path                        uint64  // identity of the ray's path, see extendPath
 }
 
 func NewRayLaunching3D(matrix [][][]float64, wallNormals []Normal3D, config RayLaunching3DConfig) *RayLaunching3D {
@@ -63,6 +121,7 @@ func NewRayLaunching3D(matrix [][][]float64, wallNormals []Normal3D, config RayL
 		WallNormals: wallNormals,
 		Config:      config,
 		RayPaths:    make([][]RayPoint, len(config.SingleRays)),
+		RayBranches: make([][]RayBranch, len(config.SingleRays)),
 	}
 }
 
@@ -85,6 +144,16 @@ func (rl *RayLaunching3D) calculateRayDirection(i, j int) (float64, float64, flo
 		dz = math.Sin(phi) * rl.Config.Step
 	}
 
+	// the direction above is in metres, on a grid of voxels that are not 1 m
+	// cubes it is scaled to voxels (the step stays at most one voxel)
+	if cellX, cellY, levelHeight := rl.voxelSize(); cellX != 1 || cellY != 1 || levelHeight != 1 {
+		dx /= cellX
+		dy /= cellY
+		dz /= levelHeight
+		length := math.Sqrt(dx*dx+dy*dy+dz*dz) / rl.Config.Step
+		dx, dy, dz = dx/length, dy/length, dz/length
+	}
+
 	dx = math.Round(dx*1e15) / 1e15
 	dy = math.Round(dy*1e15) / 1e15
 	dz = math.Round(dz*1e15) / 1e15
@@ -92,6 +161,33 @@ func (rl *RayLaunching3D) calculateRayDirection(i, j int) (float64, float64, flo
 	return dx, dy, dz
 }
 
+// voxelSize returns the size of a voxel along x, y and z in metres.
+func (rl *RayLaunching3D) voxelSize() (float64, float64, float64) {
+	cellX, cellY, levelHeight := rl.Config.CellSize, rl.Config.CellSizeY, rl.Config.LevelHeight
+	if cellX <= 0 {
+		cellX = 1
+	}
+	if cellY <= 0 {
+		cellY = cellX
+	}
+	if levelHeight <= 0 {
+		levelHeight = 1
+	}
+	return cellX, cellY, levelHeight
+}
+
+// pathLength returns the distance in metres between two positions on the grid.
+func (rl *RayLaunching3D) pathLength(p1, p2 Point3D) float64 {
+	cellX, cellY, levelHeight := rl.voxelSize()
+	if cellX == 1 && cellY == 1 && levelHeight == 1 {
+		return calculateDistance(p1, p2)
+	}
+	return calculateDistance(
+		Point3D{X: p1.X * cellX, Y: p1.Y * cellY, Z: p1.Z * levelHeight},
+		Point3D{X: p2.X * cellX, Y: p2.Y * cellY, Z: p2.Z * levelHeight},
+	)
+}
+
 func (rl *RayLaunching3D) getMapIndices(x, y, z float64) (int, int, int) {
 	xIdx := int(math.Round(x / rl.Config.Step))
 	yIdx := int(math.Round(y / rl.Config.Step))
@@ -122,15 +218,17 @@ func (rl *RayLaunching3D) handleGroundReflection(state *RayState) {
 	if state.z < 0 && state.currWallIndex != rl.Config.RoofMapNumber {
 		state.currWallIndex = rl.Config.RoofMapNumber
 		state.currInteractions++
-		state.currSumRayLength += calculateDistance(state.currStartLengthPos, Point3D{X: state.x, Y: state.y, Z: state.z})
+		state.currSumRayLength += rl.pathLength(state.currStartLengthPos, Point3D{X: state.x, Y: state.y, Z: state.z})
 		state.currStartLengthPos = Point3D{X: state.x, Y: state.y, Z: state.z}
 		nx, ny, nz := 0.0, 0.0, 1.0
 		// calculate angle of incidence
 		cosTheta := -(state.dx*nx + state.dy*ny + state.dz*nz)
 		cosTheta = rl.clampCosTheta(cosTheta)
 		theta := math.Acos(cosTheta)
-		state.currReflectionFactor *= calculateReflectionFactor(theta, "medium-dry-ground")
+		factor := calculateReflectionFactor(theta, rl.Config.Materials.groundMaterial().Permittivity)
+		state.currReflectionFactor *= factor
 		state.z = 0
+		rl.interact(state, reflection(InteractionGroundReflection, -1, -1, theta, factor))
 	}
 
 	if state.z < 0 {
@@ -146,14 +244,17 @@ func (rl *RayLaunching3D) handleRoofReflection(state *RayState, index int) bool
 		state.dz = -state.dz
 		state.currWallIndex = rl.Config.RoofMapNumber
 		state.currInteractions++
-		state.currSumRayLength += calculateDistance(state.currStartLengthPos, Point3D{X: state.x, Y: state.y, Z: state.z})
+		state.currSumRayLength += rl.pathLength(state.currStartLengthPos, Point3D{X: state.x, Y: state.y, Z: state.z})
 		state.currStartLengthPos = Point3D{X: state.x, Y: state.y, Z: state.z}
 
 		nx, ny, nz := 0.0, 0.0, 1.0
 		cosTheta := -(state.dx*nx + state.dy*ny + state.dz*nz)
 		cosTheta = rl.clampCosTheta(cosTheta)
 		theta := math.Acos(cosTheta)
-		state.currReflectionFactor *= calculateReflectionFactor(theta, "concrete")
+		xIdx, yIdx, _ := rl.getMapIndices(state.x, state.y, state.z)
+		factor := calculateReflectionFactor(theta, rl.Config.Materials.roofMaterial(xIdx, yIdx).Permittivity)
+		state.currReflectionFactor *= factor
+		rl.interact(state, reflection(InteractionRoofReflection, -1, rl.Config.Materials.buildingIndex(xIdx, yIdx), theta, factor))
 		return true
 	}
 	return false
@@ -182,14 +283,12 @@ func (rl *RayLaunching3D) calculateWallReflection(state *RayState, wallIndex int
 		dot = -dot
 	}
 	dot *= 2
-	// fmt.Printf("Promien %d, %d Nx=%.3f, Ny=%.3f, Nz=%.3f dot:%.3f \n", i, j, nx, ny, nz, dot)
-	// println("stateDx", state.dx, "stateDy", state.dy, "stateDz", state.dz)
 
 	cosTheta := -(state.dx*nx + state.dy*ny + state.dz*nz)
 	cosTheta = rl.clampCosTheta(cosTheta)
 	theta := math.Acos(cosTheta)
-	// println("theta", theta)
-	state.currReflectionFactor *= calculateReflectionFactor(theta, "concrete")
+	factor := calculateReflectionFactor(theta, rl.Config.Materials.wallMaterial(currWallIndex).Permittivity)
+	state.currReflectionFactor *= factor
 	state.dx = state.dx - dot*nx
 	state.dy = state.dy - dot*ny
 	state.dz = state.dz - dot*nz
@@ -197,40 +296,58 @@ func (rl *RayLaunching3D) calculateWallReflection(state *RayState, wallIndex int
 	state.currWallIndex = wallIndex
 
 	// sum distance and set new start position
-	state.currSumRayLength += calculateDistance(state.currStartLengthPos, Point3D{X: state.x, Y: state.y, Z: state.z})
+	state.currSumRayLength += rl.pathLength(state.currStartLengthPos, Point3D{X: state.x, Y: state.y, Z: state.z})
 	state.currStartLengthPos = Point3D{X: state.x, Y: state.y, Z: state.z}
-	// fmt.Println("Reflection Factor: %.3f", state.currReflectionFactor)
+	rl.interact(state, reflection(InteractionWallReflection, currWallIndex, rl.Config.Materials.wallBuilding(currWallIndex), theta, factor))
 }
 
-func (rl *RayLaunching3D) updatePowerMap(state *RayState, xIdx, yIdx, zIdx int) {
-	state.currRayLength = calculateDistance(state.currStartLengthPos, Point3D{X: state.x, Y: state.y, Z: state.z}) + state.currSumRayLength
+// rayPower returns the path length of the ray up to its position, the
+// diffraction loss and the power of the ray there.
+func (rl *RayLaunching3D) rayPower(state *RayState) (float64, float64, float64) {
+	rayLength := rl.pathLength(state.currStartLengthPos, Point3D{X: state.x, Y: state.y, Z: state.z}) + state.currSumRayLength
 
 	d1 := state.toDiffractionPointRayLength
-	d2 := state.currRayLength - state.toDiffractionPointRayLength
+	d2 := rayLength - state.toDiffractionPointRayLength
 
 	lambda := rl.Config.WaveLength
 	rel := float64(state.diffRayIndex) / float64(rl.Config.DiffractionRayNumber-1)
 	alpha := rel * state.diffTheta
 	baseLoss := bergDiffractionLoss(d1, d2, lambda, alpha)
 
-	state.diffLossLdB = baseLoss
-	H := calculateTransmittance(state.currRayLength, rl.Config.WaveLength, state.currReflectionFactor)
+	H := calculateTransmittance(rayLength, rl.Config.WaveLength, state.currReflectionFactor)
 	absH := cmplx.Abs(H)
 	if absH <= 0 {
 		absH = 1e-15
 	}
-	txPower := rl.Config.TransmitterPower
-	if txPower <= 0 {
-		txPower = 1e-15
+	power := 10*math.Log10(rl.transmitterPower()) + 20*math.Log10(absH) + state.antennaGaindB - baseLoss - state.penetrationLossdB
+	return rayLength, baseLoss, power
+}
+
+func (rl *RayLaunching3D) transmitterPower() float64 {
+	if rl.Config.TransmitterPower <= 0 {
+		return 1e-15
 	}
-	state.currPower = 10*math.Log10(txPower) + 20*math.Log10(absH) - state.diffLossLdB
-	// println("baseLoss: ", baseLoss, "rayIndex: ", state.diffRayIndex)
-	// println("currPorwe: ", state.currPower)
+	return rl.Config.TransmitterPower
+}
 
-	// update power map if power is higher than previous one
-	if rl.PowerMap[zIdx][yIdx][xIdx] == -160 || rl.PowerMap[zIdx][yIdx][xIdx] < state.currPower {
-		rl.PowerMap[zIdx][yIdx][xIdx] = state.currPower
+func (rl *RayLaunching3D) updatePowerMap(state *RayState, xIdx, yIdx, zIdx int) {
+	state.currRayLength, state.diffLossLdB, state.currPower = rl.rayPower(state)
+	if rl.paths != nil {
+		rl.paths.add(xIdx, yIdx, zIdx, pathSample{
+			path:   state.path,
+			power:  math.Pow(10, state.currPower/10),
+			length: rl.voxelCentreRayLength(state, xIdx, yIdx, zIdx),
+		})
+	}
+	if rl.angles != nil {
+		rl.addAngleSample(state, xIdx, yIdx, zIdx)
+	}
+
+	if rl.aggregation() != AggregationMax {
+		return
 	}
+	// update power map if power is higher than previous one
+	rl.buffer.update(xIdx, yIdx, zIdx, state.currPower)
 }
 
 func (rl *RayLaunching3D) addToRayPath(targetRayIndex int, state *RayState) {
@@ -246,11 +363,11 @@ func (rl *RayLaunching3D) addToRayPath(targetRayIndex int, state *RayState) {
 
 func (rl *RayLaunching3D) processCornerDiffraction(state *RayState, xIdx, yIdx, zIdx int, i, j int, diffractionRayNumber int, index int) {
 	state.currWallIndex = index
-	state.currSumRayLength += calculateDistance(state.currStartLengthPos, Point3D{X: state.x, Y: state.y, Z: state.z})
+	state.currSumRayLength += rl.pathLength(state.currStartLengthPos, Point3D{X: state.x, Y: state.y, Z: state.z})
 	state.toDiffractionPointRayLength = state.currSumRayLength
 	state.currStartLengthPos = Point3D{X: state.x, Y: state.y, Z: state.z}
+	rl.interact(state, interaction(InteractionDiffraction, -1, rl.Config.Materials.buildingIndex(xIdx, yIdx)))
 	normals := getNeighborWallNormals(xIdx, yIdx, zIdx, rl)
-	// fmt.Printf("xIdx: %v yIdx: %v zIdx: %v dx: %v dy: %v dz: %v rayLength: %.3f \n", xIdx, yIdx, zIdx, state.dx, state.dy, state.dz, state.currSumRayLength)
 
 	if len(normals) == 0 {
 		return
@@ -260,7 +377,7 @@ func (rl *RayLaunching3D) processCornerDiffraction(state *RayState, xIdx, yIdx,
 	var bestNormal Normal3D
 	if index == rl.Config.CornerMapNumber {
 		for _, n := range normals {
-			n.Nx, n.Ny, n.Nz = -n.Nx, n.Ny, n.Nz
+			n.Nx = -n.Nx
 			dot := state.dx*n.Nx + state.dy*n.Ny + state.dz*n.Nz
 
 			if dot > bestDot {
@@ -268,9 +385,7 @@ func (rl *RayLaunching3D) processCornerDiffraction(state *RayState, xIdx, yIdx,
 				bestNormal = n
 
 			}
-			// fmt.Printf("Promien %d, %d Normalna %d: Nx=%.3f, Ny=%.3f, Nz=%.3f Dot=%.3f\n", i, j, k, n.Nx, n.Ny, n.Nz, dot)
 		}
-		// fmt.Printf("Best Dot: %.3f, bestNormal: %v \n", bestDot, bestNormal)
 		cosTheta := state.dx*bestNormal.Nx + state.dy*bestNormal.Ny + state.dz*bestNormal.Nz
 		cosTheta = rl.clampCosTheta(cosTheta)
 		theta := math.Acos(cosTheta)
@@ -283,8 +398,6 @@ func (rl *RayLaunching3D) processCornerDiffraction(state *RayState, xIdx, yIdx,
 
 		oneStep := computeOneStep(bestNormal.Nx, bestNormal.Ny, state.dx, state.dy, finalTheta, stepResolution)
 
-		// fmt.Printf("cosTheta: %.3f theta: %.3f, finalTheta: %.3f cross: %.3f oneStep: %.3f\n", cosTheta, theta, finalTheta, cross, oneStep)
-
 		state.diffTheta = math.Abs(finalTheta)
 		rl.processDiffractionSteps(state, oneStep, stepResolution, i, j, normals, index)
 	} else if index == rl.Config.RoofCornerMapNumber {
@@ -308,10 +421,6 @@ func (rl *RayLaunching3D) processCornerDiffraction(state *RayState, xIdx, yIdx,
 
 		oneStep := (endDz - startDz) / float64(stepResolution)
 
-		// fmt.Printf(
-		// 	"Roof: dz=%.3f -> range [%.3f .. %.3f], oneStep=%.3f theta=%.3f finalTheta=%.3f\n",
-		// 	state.dz, startDz, endDz, oneStep, theta, finalTheta,
-		// )
 		state.diffTheta = finalTheta
 		rl.processDiffractionSteps(state, oneStep, stepResolution, i, j, normals, index)
 	}
@@ -346,26 +455,28 @@ func (rl *RayLaunching3D) processDiffractionSteps(state *RayState, oneStep float
 			newDz = state.dz
 		}
 
-		// fmt.Printf("Step %d: dx=%.3f dy=%.3f dz=%.3f\n", step, newDx, newDy, newDz)
-
 		x += newDx
 		y += newDy
 		z += newDz
 
-		// fmt.Printf("x: %v y: %v z: %v \n", x, y, z)
 		rl.processDiffractionRayPath(x, y, z, newDx, newDy, newDz, *state, i, j, normalsAround, index)
 	}
 }
 
 func (rl *RayLaunching3D) processDiffractionRayPath(x, y, z, newDx, newDy, newDz float64, state RayState, i, j int, normalsAround []Normal3D, startIndex int) {
+	if state.targetRayIndex >= 0 {
+		xIdx, yIdx, _ := rl.getMapIndices(state.x, state.y, state.z)
+		vertex := interaction(InteractionDiffraction, -1, rl.Config.Materials.buildingIndex(xIdx, yIdx))
+		rel := float64(state.diffRayIndex) / float64(rl.Config.DiffractionRayNumber-1)
+		vertex.DiffractionAngle = rel * state.diffTheta * 180 / math.Pi
+		rl.startBranch(&state, vertex)
+	}
 	state.dx, state.dy, state.dz = newDx, newDy, newDz
 	state.x, state.y, state.z = x, y, z
 	for rl.shouldContinueRay(&state) {
 		rl.handleGroundReflection(&state)
 		xIdx, yIdx, zIdx := rl.getMapIndices(state.x, state.y, state.z)
 		index := int(rl.PowerMap[zIdx][yIdx][xIdx])
-		// fmt.Println("xIdx: ", xIdx, "yIdx: ", yIdx, "zIdx: ", zIdx, "index: ", index, "currWallIndex: ", state.currWallIndex)
-		// fmt.Println("x: ", state.x, "y: ", state.y, "z: ", state.z)
 		if rl.shouldBreakRayPropagation(&state, index) || ((state.currWallIndex == rl.Config.RoofMapNumber) && newDz > 0) {
 			break
 		}
@@ -378,6 +489,7 @@ func (rl *RayLaunching3D) processDiffractionRayPath(x, y, z, newDx, newDy, newDz
 			normalIndex := index - rl.Config.WallMapNumber
 
 			if !containsNormal(normalsAround, rl.WallNormals[normalIndex]) {
+				rl.penetrateWall(state, index)
 				rl.calculateWallReflection(&state, index, i, j)
 			}
 			rl.updatePowerMap(&state, xIdx, yIdx, zIdx)
@@ -390,64 +502,91 @@ func (rl *RayLaunching3D) processDiffractionRayPath(x, y, z, newDx, newDy, newDz
 		state.y += state.dy
 		state.z += state.dz
 	}
+	rl.addVertex(&state, interaction(InteractionTermination, -1, -1))
 }
 
 func (rl *RayLaunching3D) CreatePowerMapLegend() {
 	legend := make(map[int]PowerMapLegendEntry)
 
 	for z := 0; z < len(rl.PowerMap); z++ {
-		entry := PowerMapLegendEntry{}
-		var coveredPoints, totalPoints float64
+		if rl.IndoorMap == nil {
+			legend[z] = rl.createLegendEntry(z, nil)
+			continue
+		}
+		entry := rl.createLegendEntry(z, func(x, y int) bool { return true })
+		indoor := rl.createLegendEntry(z, func(x, y int) bool { return rl.IndoorMap[z][y][x] })
+		outdoor := rl.createLegendEntry(z, func(x, y int) bool { return !rl.IndoorMap[z][y][x] })
+		entry.Indoor = &indoor
+		entry.Outdoor = &outdoor
+		legend[z] = entry
+	}
 
-		for y := 0; y < len(rl.PowerMap[z]); y++ {
-			for x := 0; x < len(rl.PowerMap[z][y]); x++ {
-				power := rl.PowerMap[z][y][x]
-				if power < 0 {
+	rl.PowerMapLegend = legend
+}
+
+// createLegendEntry summarises floor z. Without a filter only open air voxels
+// are counted, with a filter every voxel it accepts is, including building
+// interiors no ray reached.
+func (rl *RayLaunching3D) createLegendEntry(z int, filter func(x, y int) bool) PowerMapLegendEntry {
+	entry := PowerMapLegendEntry{}
+	var coveredPoints, totalPoints float64
+
+	for y := 0; y < len(rl.PowerMap[z]); y++ {
+		for x := 0; x < len(rl.PowerMap[z][y]); x++ {
+			power := rl.PowerMap[z][y][x]
+			if filter != nil {
+				if !filter(x, y) {
+					continue
+				}
+				if rl.IndoorMap[z][y][x] && power == float64(rl.Config.BuldingInteriorNumber) {
 					totalPoints++
-					if power > -159.9 {
-						coveredPoints++
-						loss := math.Abs(power)
-						switch {
-						case loss < 20:
-							entry.P0_20++
-						case loss < 40:
-							entry.P20_40++
-						case loss < 60:
-							entry.P40_60++
-						case loss < 80:
-							entry.P60_80++
-						case loss < 100:
-							entry.P80_100++
-						case loss < 120:
-							entry.P100_120++
-						case loss < 140:
-							entry.P120_140++
-						default:
-							entry.P140plus++
-						}
+					continue
+				}
+			}
+			if power < 0 {
+				totalPoints++
+				if power > -159.9 {
+					coveredPoints++
+					loss := math.Abs(power)
+					switch {
+					case loss < 20:
+						entry.P0_20++
+					case loss < 40:
+						entry.P20_40++
+					case loss < 60:
+						entry.P40_60++
+					case loss < 80:
+						entry.P60_80++
+					case loss < 100:
+						entry.P80_100++
+					case loss < 120:
+						entry.P100_120++
+					case loss < 140:
+						entry.P120_140++
+					default:
+						entry.P140plus++
 					}
 				}
 			}
 		}
+	}
 
-		if coveredPoints > 0 {
-			scale := 100.0 / coveredPoints
-			entry.P0_20 *= scale
-			entry.P20_40 *= scale
-			entry.P40_60 *= scale
-			entry.P60_80 *= scale
-			entry.P80_100 *= scale
-			entry.P100_120 *= scale
-			entry.P120_140 *= scale
-			entry.P140plus *= scale
-		}
+	if coveredPoints > 0 {
+		scale := 100.0 / coveredPoints
+		entry.P0_20 *= scale
+		entry.P20_40 *= scale
+		entry.P40_60 *= scale
+		entry.P60_80 *= scale
+		entry.P80_100 *= scale
+		entry.P100_120 *= scale
+		entry.P120_140 *= scale
+		entry.P140plus *= scale
+	}
 
+	if totalPoints > 0 {
 		entry.Ptotal = (coveredPoints / totalPoints) * 100.0
-
-		legend[z] = entry
 	}
-
-	rl.PowerMapLegend = legend
+	return entry
 }
 
 func (rl *RayLaunching3D) PrintPowerMapLegend() {
@@ -469,89 +608,107 @@ func (rl *RayLaunching3D) PrintPowerMapLegend() {
 }
 
 func (rl *RayLaunching3D) CalculateRayLaunching3D() {
+	rl.CalculateRayLaunching3DContext(context.Background(), nil)
+}
+
+// CalculateRayLaunching3DContext runs the simulation like CalculateRayLaunching3D,
+// but checks ctx between azimuth steps and returns ctx.Err() when it is cancelled.
+// If progress is not nil it is called after every azimuth step with the number of
+// finished and total steps.
+func (rl *RayLaunching3D) CalculateRayLaunching3DContext(ctx context.Context, progress func(done, total int)) error {
 	for z := 0; z < int(rl.Config.TransmitterPos.Z); z++ {
 		rl.PowerMap[z][int(rl.Config.TransmitterPos.Y)][int(rl.Config.TransmitterPos.X)] = 0
 	}
+	if rl.Config.WallPenetration {
+		rl.IndoorMap = rl.createIndoorMap()
+	}
 
-	for i := 0; i < rl.Config.NumOfRaysAzim; i++ { // loop over horizontal dim
-		for j := 0; j < rl.Config.NumOfRaysElev; j++ { // loop over vertical dim
-			dx, dy, dz := rl.calculateRayDirection(i, j)
-			// main loop
-			// if !(i == 15 && j == 13) {
-			// 	continue
-			// }
-			targetRayIndex := rl.isTargetRay(i, j)
-			state := &RayState{
-				x:  rl.Config.TransmitterPos.X + dx,
-				y:  rl.Config.TransmitterPos.Y + dy,
-				z:  rl.Config.TransmitterPos.Z + dz,
-				dx: dx, dy: dy, dz: dz,
-				currInteractions:            0,
-				currPower:                   0.0,
-				currWallIndex:               0,
-				currStartLengthPos:          Point3D{X: rl.Config.TransmitterPos.X, Y: rl.Config.TransmitterPos.Y, Z: rl.Config.TransmitterPos.Z},
-				currRayLength:               0.0,
-				currSumRayLength:            0.0,
-				currReflectionFactor:        1.0,
-				diffLossLdB:                 0.0,
-				targetRayIndex:              targetRayIndex,
-				toDiffractionPointRayLength: 0.0,
-				diffTheta:                   0.0,
-				diffRayIndex:                0,
-			}
-
-			for rl.shouldContinueRay(state) {
-				// reflection from the ground when z is below 0
-				rl.handleGroundReflection(state)
-
-				xIdx, yIdx, zIdx := rl.getMapIndices(state.x, state.y, state.z)
-				index := int(rl.PowerMap[zIdx][yIdx][xIdx])
-				// fmt.Println("xIdx: ", xIdx, "yIdx: ", yIdx, "zIdx: ", zIdx, "index: ", index, "currWallIndex: ", state.currWallIndex)
-				if rl.shouldBreakRayPropagation(state, index) || (index == rl.Config.RoofCornerMapNumber && state.dz == 0) {
-					break
-				}
-				// reflection from the building roof
-				if rl.handleRoofReflection(state, index) {
-					continue
-				}
+	if err := rl.launchRays(ctx, progress); err != nil {
+		return err
+	}
 
-				if (index == rl.Config.CornerMapNumber && state.currWallIndex != rl.Config.CornerMapNumber) || (index == rl.Config.RoofCornerMapNumber && state.currWallIndex != rl.Config.CornerMapNumber && !(state.currWallIndex >= rl.Config.WallMapNumber && index < rl.Config.RoofMapNumber)) {
-					if rl.Config.DiffractionRayNumber < 2 {
-						break
-					}
-					nextXIdx, nextYIdx, nextZIdx := rl.getMapIndices(state.x+state.dx, state.y+state.dy, state.z+state.dz)
-					// fmt.Println("NextXIdx: ", nextXIdx, "NextYIdx: ", nextYIdx, "NextZIdx: ", nextZIdx)
-					if nextZIdx < 0 || !rl.isValidPosition(float64(nextXIdx), float64(nextYIdx), float64(nextZIdx)) {
-						break
-					}
+	rl.CreatePowerMapLegend()
+	return nil
+}
+
+// traceRay follows the ray launched in direction (i, j) and records its power
+// in rl.buffer.
+func (rl *RayLaunching3D) traceRay(i, j int) {
+	dx, dy, dz := rl.calculateRayDirection(i, j)
+	cellX, cellY, levelHeight := rl.voxelSize()
+	targetRayIndex := rl.isTargetRay(i, j)
+	state := &RayState{
+		x:  rl.Config.TransmitterPos.X + dx,
+		y:  rl.Config.TransmitterPos.Y + dy,
+		z:  rl.Config.TransmitterPos.Z + dz,
+		dx: dx, dy: dy, dz: dz,
+		currInteractions:            0,
+		currPower:                   0.0,
+		currWallIndex:               0,
+		currStartLengthPos:          Point3D{X: rl.Config.TransmitterPos.X, Y: rl.Config.TransmitterPos.Y, Z: rl.Config.TransmitterPos.Z},
+		currRayLength:               0.0,
+		currSumRayLength:            0.0,
+		currReflectionFactor:        1.0,
+		diffLossLdB:                 0.0,
+		targetRayIndex:              targetRayIndex,
+		toDiffractionPointRayLength: 0.0,
+		diffTheta:                   0.0,
+		diffRayIndex:                0,
+		antennaGaindB:               antennaGain(rl.Config.Antenna, dx*cellX, dy*cellY, dz*levelHeight),
+		launchDir:                   Point3D{X: dx, Y: dy, Z: dz},
+		path:                        pathOrigin,
+	}
+	rl.startBranch(state, interaction(InteractionLaunch, -1, -1))
+
+	for rl.shouldContinueRay(state) {
+		// reflection from the ground when z is below 0
+		rl.handleGroundReflection(state)
 
-					nextIndex := int(rl.PowerMap[nextZIdx][nextYIdx][nextXIdx])
-					if nextIndex == rl.Config.RoofMapNumber {
-						break
-					}
+		xIdx, yIdx, zIdx := rl.getMapIndices(state.x, state.y, state.z)
+		index := int(rl.PowerMap[zIdx][yIdx][xIdx])
+		if rl.shouldBreakRayPropagation(state, index) || (index == rl.Config.RoofCornerMapNumber && state.dz == 0) {
+			break
+		}
+		// reflection from the building roof
+		if rl.handleRoofReflection(state, index) {
+			continue
+		}
 
-					if !(state.currWallIndex >= rl.Config.WallMapNumber && state.currWallIndex < rl.Config.RoofMapNumber) {
-						rl.processCornerDiffraction(state, xIdx, yIdx, zIdx, i, j, rl.Config.DiffractionRayNumber-1, index)
-						break
-					}
-				}
+		if (index == rl.Config.CornerMapNumber && state.currWallIndex != rl.Config.CornerMapNumber) || (index == rl.Config.RoofCornerMapNumber && state.currWallIndex != rl.Config.CornerMapNumber && !(state.currWallIndex >= rl.Config.WallMapNumber && index < rl.Config.RoofMapNumber)) {
+			if rl.Config.DiffractionRayNumber < 2 {
+				break
+			}
+			nextXIdx, nextYIdx, nextZIdx := rl.getMapIndices(state.x+state.dx, state.y+state.dy, state.z+state.dz)
+			if nextZIdx < 0 || !rl.isValidPosition(float64(nextXIdx), float64(nextYIdx), float64(nextZIdx)) {
+				break
+			}
 
-				if index >= rl.Config.WallMapNumber && index < rl.Config.RoofMapNumber && index != state.currWallIndex {
-					rl.calculateWallReflection(state, index, i, j)
-				} else {
-					rl.updatePowerMap(state, xIdx, yIdx, zIdx)
-					rl.addToRayPath(targetRayIndex, state)
-				}
+			nextIndex := int(rl.PowerMap[nextZIdx][nextYIdx][nextXIdx])
+			if nextIndex == rl.Config.RoofMapNumber {
+				break
+			}
 
-				// update position
-				state.x += state.dx
-				state.y += state.dy
-				state.z += state.dz
+			if !(state.currWallIndex >= rl.Config.WallMapNumber && state.currWallIndex < rl.Config.RoofMapNumber) {
+				// the rays of the diffraction fan continue the ray
+				rl.processCornerDiffraction(state, xIdx, yIdx, zIdx, i, j, rl.Config.DiffractionRayNumber-1, index)
+				return
 			}
 		}
-	}
 
-	rl.CreatePowerMapLegend()
+		if index >= rl.Config.WallMapNumber && index < rl.Config.RoofMapNumber && index != state.currWallIndex {
+			rl.penetrateWall(*state, index)
+			rl.calculateWallReflection(state, index, i, j)
+		} else {
+			rl.updatePowerMap(state, xIdx, yIdx, zIdx)
+			rl.addToRayPath(targetRayIndex, state)
+		}
+
+		// update position
+		state.x += state.dx
+		state.y += state.dy
+		state.z += state.dz
+	}
+	rl.addVertex(state, interaction(InteractionTermination, -1, -1))
 }
 
 func calculateDistance(p1, p2 Point3D) float64 {
@@ -580,19 +737,12 @@ func (rl *RayLaunching3D) isTargetRay(i, j int) int {
 	return -1
 }
 
-func calculateReflectionFactor(angle float64, material string) float64 {
+// calculateReflectionFactor returns the mean of the TE and TM power reflection
+// coefficients for a material with relative permittivity eta.
+func calculateReflectionFactor(angle float64, eta float64) float64 {
 	if angle > math.Pi/2 {
 		angle = math.Pi - angle
 	}
-	var eta float64
-	switch material {
-	case "concrete":
-		eta = 5.31
-	case "ceiling-board":
-		eta = 1.50
-	case "medium-dry-ground":
-		eta = 15
-	}
 	sinTheta := math.Sin(angle)
 	cosTheta := math.Cos(angle)
 	if cosTheta > 1 {
@@ -685,7 +835,6 @@ func computeOneStep(Nx, Ny, dx, dy, finalTheta float64, stepResolution int) floa
 }
 
 func diffractionLoss(d1, d2, lambda, alpha float64) float64 {
-	println("BergDiffractionLoss d1:", d1, " d2:", d2, " lambda:", lambda, " alpha:", alpha)
 	if d1 <= 0 || d2 <= 0 || lambda <= 0 || alpha <= 0 || alpha >= math.Pi {
 		return 0
 	}
@@ -713,7 +862,6 @@ func bergDiffractionLoss(d1, d2, lambda, alpha_rad float64) float64 {
 
 	alpha_deg := alpha_rad * 180.0 / math.Pi
 	q90 := math.Sqrt(q_lambda / lambda)
-	// println("alpha_deg:", alpha_deg, " q90:", q90)
 	q1 := q90 * math.Pow(alpha_deg/90.0, v)
 
 	d_real := d1 + d2
@@ -0,0 +1,206 @@
+package raylaunching
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"sync"
+)
+
+const powerTileSize = 32
+
+// powerBuffer keeps the highest power seen per voxel by a single worker.
+// Memory is allocated in powerTileSize x powerTileSize tiles on first write,
+// because a worker only reaches the part of the map its rays travel through.
+type powerBuffer struct {
+	sizeX, sizeY, sizeZ int
+	tilesX, tilesY      int
+	tiles               [][]float64
+}
+
+func newPowerBuffer(sizeX, sizeY, sizeZ int) *powerBuffer {
+	tilesX := (sizeX + powerTileSize - 1) / powerTileSize
+	tilesY := (sizeY + powerTileSize - 1) / powerTileSize
+	return &powerBuffer{
+		sizeX:  sizeX,
+		sizeY:  sizeY,
+		sizeZ:  sizeZ,
+		tilesX: tilesX,
+		tilesY: tilesY,
+		tiles:  make([][]float64, tilesX*tilesY*sizeZ),
+	}
+}
+
+// tile returns the tile holding voxel (x, y, z) and the index of the voxel in
+// it. New tiles are filled with NaN, which marks voxels no ray reached.
+func (b *powerBuffer) tile(x, y, z int) ([]float64, int) {
+	tileIndex := (z*b.tilesY+y/powerTileSize)*b.tilesX + x/powerTileSize
+	tile := b.tiles[tileIndex]
+	if tile == nil {
+		tile = make([]float64, powerTileSize*powerTileSize)
+		for k := range tile {
+			tile[k] = math.NaN()
+		}
+		b.tiles[tileIndex] = tile
+	}
+	return tile, (y%powerTileSize)*powerTileSize + x%powerTileSize
+}
+
+func (b *powerBuffer) update(x, y, z int, power float64) {
+	tile, k := b.tile(x, y, z)
+	if math.IsNaN(tile[k]) || tile[k] < power {
+		tile[k] = power
+	}
+}
+
+// mergeInto applies the buffered power to powerMap with the same rule the
+// serial algorithm uses for every single ray: an empty voxel takes any power,
+// any other voxel only a higher one. Taking the maximum per worker first does
+// not change the result, so the merge is independent of how rays were split
+// between workers.
+func (b *powerBuffer) mergeInto(powerMap [][][]float64, isEmpty func(x, y, z int, value float64) bool) {
+	for tileIndex, tile := range b.tiles {
+		if tile == nil {
+			continue
+		}
+		z := tileIndex / (b.tilesX * b.tilesY)
+		tileY := (tileIndex / b.tilesX) % b.tilesY
+		tileX := tileIndex % b.tilesX
+		for k, power := range tile {
+			if math.IsNaN(power) {
+				continue
+			}
+			x := tileX*powerTileSize + k%powerTileSize
+			y := tileY*powerTileSize + k/powerTileSize
+			if isEmpty(x, y, z, powerMap[z][y][x]) || powerMap[z][y][x] < power {
+				powerMap[z][y][x] = power
+			}
+		}
+	}
+}
+
+func (rl *RayLaunching3D) numOfWorkers() int {
+	workers := rl.Config.NumOfWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > rl.Config.NumOfRaysAzim {
+		workers = rl.Config.NumOfRaysAzim
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+// launchRays splits the azimuth range into contiguous blocks, one per worker.
+// Each worker reads the geometry from the shared PowerMap and accumulates
+// power, paths and ray paths in its own buffers. Once every worker is done
+// the buffers are merged into PowerMap, RayPaths and RayBranches in worker
+// order, and the paths and directions of all workers into the sums, delay
+// maps, profiles and receiver angles.
+func (rl *RayLaunching3D) launchRays(ctx context.Context, progress func(done, total int)) error {
+	sizeZ := len(rl.PowerMap)
+	if sizeZ == 0 {
+		return nil
+	}
+	sizeY := len(rl.PowerMap[0])
+	sizeX := len(rl.PowerMap[0][0])
+
+	numOfWorkers := rl.numOfWorkers()
+	workers := make([]*RayLaunching3D, numOfWorkers)
+	errs := make([]error, numOfWorkers)
+
+	var progressMu sync.Mutex
+	done := 0
+	reportProgress := func() {
+		if progress == nil {
+			return
+		}
+		progressMu.Lock()
+		done++
+		progress(done, rl.Config.NumOfRaysAzim)
+		progressMu.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < numOfWorkers; w++ {
+		workers[w] = &RayLaunching3D{
+			PowerMap:    rl.PowerMap,
+			WallNormals: rl.WallNormals,
+			Config:      rl.Config,
+			RayPaths:    make([][]RayPoint, len(rl.Config.SingleRays)),
+			RayBranches: make([][]RayBranch, len(rl.Config.SingleRays)),
+			buffer:      newPowerBuffer(sizeX, sizeY, sizeZ),
+			paths:       rl.newPathBuffer(sizeX, sizeY, sizeZ),
+		}
+		if len(rl.Config.Receivers) > 0 {
+			workers[w].angles = newAngleBuffer(rl.Config)
+		}
+		first := w * rl.Config.NumOfRaysAzim / numOfWorkers
+		last := (w + 1) * rl.Config.NumOfRaysAzim / numOfWorkers
+
+		wg.Add(1)
+		go func(w, first, last int) {
+			defer wg.Done()
+			worker := workers[w]
+			for i := first; i < last; i++ { // loop over horizontal dim
+				if err := ctx.Err(); err != nil {
+					errs[w] = err
+					return
+				}
+				for j := 0; j < rl.Config.NumOfRaysElev; j++ { // loop over vertical dim
+					worker.traceRay(i, j)
+				}
+				reportProgress()
+			}
+		}(w, first, last)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	for _, worker := range workers {
+		if rl.aggregation() == AggregationMax {
+			worker.buffer.mergeInto(rl.PowerMap, rl.isEmptyVoxel)
+		}
+		for idx, path := range worker.RayPaths {
+			rl.RayPaths[idx] = append(rl.RayPaths[idx], path...)
+		}
+		for idx, branches := range worker.RayBranches {
+			rl.RayBranches[idx] = append(rl.RayBranches[idx], branches...)
+		}
+	}
+	if paths := workers[0].paths; paths != nil {
+		// the paths of all workers are merged before they are summed, a path
+		// traced by several workers still counts once
+		for _, worker := range workers[1:] {
+			paths.merge(worker.paths)
+		}
+		if rl.aggregation() != AggregationMax {
+			sums := newPowerBuffer(sizeX, sizeY, sizeZ)
+			paths.forEach(func(x, y, z int, samples []pathSample) {
+				if power := rl.pathSum(samples); power > 0 {
+					sums.update(x, y, z, 10*math.Log10(power))
+				}
+			})
+			sums.mergeInto(rl.PowerMap, rl.isEmptyVoxel)
+		}
+		if rl.Config.DelaySpread {
+			rl.MeanExcessDelayMap, rl.RMSDelaySpreadMap = delayMaps(paths, rl.PowerMap, rl.hasSignal)
+		}
+		if rl.Config.DelaySpread || len(rl.Config.Receivers) > 0 {
+			rl.PowerDelayProfiles = powerDelayProfiles(paths, rl.Config.Receivers, rl.Config.DelayResolution)
+		}
+	}
+	if angles := workers[0].angles; angles != nil {
+		for _, worker := range workers[1:] {
+			angles.merge(worker.angles)
+		}
+		rl.ReceiverAngles = angles.receiverAngles(rl.Config.Receivers)
+	}
+	return nil
+}
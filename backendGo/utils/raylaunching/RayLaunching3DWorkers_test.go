@@ -0,0 +1,43 @@
+package raylaunching
+
+import (
+	. "backendGo/types"
+	"reflect"
+	"testing"
+)
+
+// TestWorkersMatchSerialRun checks that splitting the rays across workers
+// gives the power map, ray paths and branches of a single worker.
+func TestWorkersMatchSerialRun(t *testing.T) {
+	run := func(workers int) *RayLaunching3D {
+		matrix, normals := testMap(40, 40, 8, 30)
+		config := testConfig(40, 40, 8, Point3D{X: 10, Y: 20, Z: 3})
+		config.NumOfWorkers = workers
+		// towards the wall, over the ground to the map edge and down to the ground
+		config.SingleRays = []SingleRay{{Azimuth: 0, Elevation: 45}, {Azimuth: 200, Elevation: 45}, {Azimuth: 350, Elevation: 40}}
+		rl := NewRayLaunching3D(matrix, normals, config)
+		rl.CalculateRayLaunching3D()
+		return rl
+	}
+	serial := run(1)
+	for _, path := range serial.RayPaths {
+		if len(path) == 0 {
+			t.Fatalf("a target ray has no path: %v", serial.RayPaths)
+		}
+	}
+	for _, workers := range []int{2, 7} {
+		parallel := run(workers)
+		if !reflect.DeepEqual(parallel.PowerMap, serial.PowerMap) {
+			t.Errorf("%d workers: power map differs from the serial run", workers)
+		}
+		if !reflect.DeepEqual(parallel.RayPaths, serial.RayPaths) {
+			t.Errorf("%d workers: ray paths differ from the serial run", workers)
+		}
+		if !reflect.DeepEqual(parallel.RayBranches, serial.RayBranches) {
+			t.Errorf("%d workers: ray branches differ from the serial run", workers)
+		}
+		if !reflect.DeepEqual(parallel.PowerMapLegend, serial.PowerMapLegend) {
+			t.Errorf("%d workers: power map legend differs from the serial run", workers)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package raylaunching
+
+import (
+	. "backendGo/types"
+	"math"
+	"testing"
+)
+
+func TestPathLength(t *testing.T) {
+	from, to := Point3D{X: 1, Y: 1, Z: 1}, Point3D{X: 4, Y: 5, Z: 3}
+	for _, test := range []struct {
+		name                             string
+		cellSize, cellSizeY, levelHeight float64
+		want                             float64
+	}{
+		{"1 m voxels", 0, 0, 0, math.Sqrt(9 + 16 + 4)},
+		{"square cells", 2, 0, 3, math.Sqrt(36 + 64 + 36)},
+		{"legacy linear grid", 0.7, 1.1, 1, math.Sqrt(3*0.7*3*0.7 + 4*1.1*4*1.1 + 4)},
+	} {
+		rl := &RayLaunching3D{Config: RayLaunching3DConfig{CellSize: test.cellSize, CellSizeY: test.cellSizeY, LevelHeight: test.levelHeight}}
+		if got := rl.pathLength(from, to); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: path length %g m, want %g m", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package raylaunching
+
+import . "backendGo/types"
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+type GeoJSONFeature struct {
+	Type       string              `json:"type"`
+	Geometry   GeoJSONLineString   `json:"geometry"`
+	Properties RayBranchProperties `json:"properties"`
+}
+
+// GeoJSONLineString has [longitude, latitude, altitude in metres] positions.
+type GeoJSONLineString struct {
+	Type        string       `json:"type"`
+	Coordinates [][3]float64 `json:"coordinates"`
+}
+
+// RayBranchProperties describe a branch of a target ray, Vertices has one
+// entry per position of the line.
+type RayBranchProperties struct {
+	Station   int                   `json:"station"`
+	Ray       int                   `json:"ray"` // index into SingleRays
+	Azimuth   int                   `json:"azimuth"`
+	Elevation int                   `json:"elevation"`
+	Branch    int                   `json:"branch"`
+	Vertices  []RayVertexProperties `json:"vertices"`
+}
+
+type RayVertexProperties struct {
+	Type         string  `json:"type"`
+	Power        float64 `json:"power"`
+	Length       float64 `json:"length"`
+	Wall         int     `json:"wall"`
+	Building     int     `json:"building"`
+	BuildingName string  `json:"buildingName,omitempty"`
+
+	IncidenceAngle   float64 `json:"incidenceAngle"`
+	ReflectionFactor float64 `json:"reflectionFactor"`
+	DiffractionAngle float64 `json:"diffractionAngle"`
+	DiffractionLoss  float64 `json:"diffractionLoss"`
+	PenetrationLoss  float64 `json:"penetrationLoss"`
+}
+
+// RayBranchesGeoJSON returns the branches of the target rays of every station
+// (indexed like singleRays) as LineStrings with a vertex per interaction.
+// toGeo converts voxel coordinates to a GeoJSON position.
+func RayBranchesGeoJSON(stations [][][]RayBranch, singleRays []SingleRay, toGeo func(x, y, z float64) [3]float64) GeoJSONFeatureCollection {
+	collection := GeoJSONFeatureCollection{Type: "FeatureCollection", Features: []GeoJSONFeature{}}
+	for s, rays := range stations {
+		for r, branches := range rays {
+			for b, branch := range branches {
+				if len(branch) < 2 || r >= len(singleRays) {
+					continue
+				}
+				feature := GeoJSONFeature{
+					Type:     "Feature",
+					Geometry: GeoJSONLineString{Type: "LineString", Coordinates: make([][3]float64, len(branch))},
+					Properties: RayBranchProperties{
+						Station:   s,
+						Ray:       r,
+						Azimuth:   singleRays[r].Azimuth,
+						Elevation: singleRays[r].Elevation,
+						Branch:    b,
+						Vertices:  make([]RayVertexProperties, len(branch)),
+					},
+				}
+				for v, vertex := range branch {
+					feature.Geometry.Coordinates[v] = toGeo(vertex.X, vertex.Y, vertex.Z)
+					feature.Properties.Vertices[v] = RayVertexProperties{
+						Type:         vertex.Type,
+						Power:        vertex.Power,
+						Length:       vertex.Length,
+						Wall:         vertex.Wall,
+						Building:     vertex.Building,
+						BuildingName: vertex.BuildingName,
+
+						IncidenceAngle:   vertex.IncidenceAngle,
+						ReflectionFactor: vertex.ReflectionFactor,
+						DiffractionAngle: vertex.DiffractionAngle,
+						DiffractionLoss:  vertex.DiffractionLoss,
+						PenetrationLoss:  vertex.PenetrationLoss,
+					}
+				}
+				collection.Features = append(collection.Features, feature)
+			}
+		}
+	}
+	return collection
+}
@@ -0,0 +1,215 @@
+package raylaunching
+
+import (
+	. "backendGo/types"
+	"math"
+	"math/cmplx"
+	"sort"
+)
+
+// AngularBin is the power arriving from (or departing into) the directions of
+// one bin of Config.AngleResolution degrees, given by the bin's centre.
+type AngularBin struct {
+	Azimuth   float64 `json:"azimuth"`
+	Elevation float64 `json:"elevation"`
+	Power     float64 `json:"power"` // in the unit of the power map
+}
+
+// AngleStatistics describe the directions of the paths reaching a receiver.
+// Azimuths are measured in degrees from the x axis of the grid towards its y
+// axis, like the azimuths of launched rays, elevations from the horizontal
+// plane. Azimuth and Elevation are the centre of the strongest bin of
+// Spectrum. AzimuthSpread is the circular standard deviation of the azimuths
+// and ElevationSpread the standard deviation of the elevations, both power
+// weighted and taken from the unbinned paths.
+type AngleStatistics struct {
+	Azimuth         float64      `json:"azimuth"`
+	Elevation       float64      `json:"elevation"`
+	AzimuthSpread   float64      `json:"azimuthSpread"`
+	ElevationSpread float64      `json:"elevationSpread"`
+	Spectrum        []AngularBin `json:"spectrum"`
+}
+
+// ReceiverAngles are the angles of arrival (the directions the rays come
+// from) and of departure (the directions they were launched in) at a receiver
+// of Config.Receivers. Both are nil when no ray reaches the receiver.
+type ReceiverAngles struct {
+	Receiver  Point3D          `json:"receiver"`
+	Power     float64          `json:"power"`
+	Arrival   *AngleStatistics `json:"aoa"`
+	Departure *AngleStatistics `json:"aod"`
+}
+
+// angleMoments collects the power weighted directions of the paths reaching a
+// receiver, azimuth as a sum of unit phasors for the circular spread.
+type angleMoments struct {
+	power                 float64
+	azimuth               complex128
+	elevation, elevation2 float64
+	spectrum              map[[2]int]float64 // linear power per azimuth and elevation bin
+}
+
+func newAngleMoments() angleMoments {
+	return angleMoments{spectrum: make(map[[2]int]float64)}
+}
+
+func (m *angleMoments) add(azimuth, elevation, power, resolution float64) {
+	m.power += power
+	m.azimuth += cmplx.Rect(power, azimuth*math.Pi/180)
+	m.elevation += power * elevation
+	m.elevation2 += power * elevation * elevation
+	m.spectrum[angularBin(azimuth, elevation, resolution)] += power
+}
+
+// angularBin returns the bin of a direction, elevation +90° falls into the
+// topmost bin.
+func angularBin(azimuth, elevation, resolution float64) [2]int {
+	azimuthBins := int(math.Ceil(360 / resolution))
+	elevationBins := int(math.Ceil(180 / resolution))
+	azimuthBin := int(math.Floor(azimuth/resolution)) % azimuthBins
+	if azimuthBin < 0 {
+		azimuthBin += azimuthBins
+	}
+	elevationBin := min(int(math.Floor((elevation+90)/resolution)), elevationBins-1)
+	return [2]int{azimuthBin, max(elevationBin, 0)}
+}
+
+func (m angleMoments) statistics(resolution float64) *AngleStatistics {
+	if m.power <= 0 {
+		return nil
+	}
+	stats := &AngleStatistics{Spectrum: make([]AngularBin, 0, len(m.spectrum))}
+	// the mean resultant length of the unit phasors gives the circular spread
+	if length := cmplx.Abs(m.azimuth) / m.power; length < 1 {
+		stats.AzimuthSpread = math.Sqrt(-2*math.Log(length)) * 180 / math.Pi
+	}
+	mean := m.elevation / m.power
+	stats.ElevationSpread = math.Sqrt(math.Max(0, m.elevation2/m.power-mean*mean))
+
+	bins := make([][2]int, 0, len(m.spectrum))
+	for bin := range m.spectrum {
+		bins = append(bins, bin)
+	}
+	sort.Slice(bins, func(a, b int) bool {
+		if bins[a][0] != bins[b][0] {
+			return bins[a][0] < bins[b][0]
+		}
+		return bins[a][1] < bins[b][1]
+	})
+	strongest := 0.0
+	for _, bin := range bins {
+		power := m.spectrum[bin]
+		azimuth := (float64(bin[0]) + 0.5) * resolution
+		elevation := (float64(bin[1])+0.5)*resolution - 90
+		if power > strongest {
+			strongest = power
+			stats.Azimuth, stats.Elevation = azimuth, elevation
+		}
+		stats.Spectrum = append(stats.Spectrum, AngularBin{Azimuth: azimuth, Elevation: elevation, Power: 10 * math.Log10(power)})
+	}
+	return stats
+}
+
+// angleSample is the strongest sample of a path at a receiver: its power in
+// W and the azimuth and elevation it arrives from and was launched in.
+type angleSample struct {
+	power                                float64
+	arrivalAzimuth, arrivalElevation     float64
+	departureAzimuth, departureElevation float64
+}
+
+// angleBuffer collects the paths of the rays traced by one worker at the
+// receivers, each path keeps its strongest sample like in pathBuffer.
+type angleBuffer struct {
+	resolution float64
+	receivers  map[[3]int][]int
+	paths      []map[uint64]angleSample
+}
+
+func newAngleBuffer(config RayLaunching3DConfig) *angleBuffer {
+	b := &angleBuffer{
+		resolution: config.AngleResolution,
+		receivers:  receiverVoxels(config.Receivers),
+		paths:      make([]map[uint64]angleSample, len(config.Receivers)),
+	}
+	if b.resolution <= 0 {
+		b.resolution = 5
+	}
+	for i := range b.paths {
+		b.paths[i] = make(map[uint64]angleSample)
+	}
+	return b
+}
+
+func (b *angleBuffer) add(receiver int, path uint64, sample angleSample) {
+	if previous, ok := b.paths[receiver][path]; !ok || sample.power > previous.power {
+		b.paths[receiver][path] = sample
+	}
+}
+
+func (b *angleBuffer) merge(other *angleBuffer) {
+	for i, paths := range other.paths {
+		for path, sample := range paths {
+			b.add(i, path, sample)
+		}
+	}
+}
+
+func (b *angleBuffer) receiverAngles(receivers []Point3D) []ReceiverAngles {
+	angles := make([]ReceiverAngles, len(receivers))
+	for i, receiver := range receivers {
+		arrival, departure := newAngleMoments(), newAngleMoments()
+		for _, sample := range b.paths[i] {
+			arrival.add(sample.arrivalAzimuth, sample.arrivalElevation, sample.power, b.resolution)
+			departure.add(sample.departureAzimuth, sample.departureElevation, sample.power, b.resolution)
+		}
+		angles[i] = ReceiverAngles{
+			Receiver:  receiver,
+			Power:     NoSignal,
+			Arrival:   arrival.statistics(b.resolution),
+			Departure: departure.statistics(b.resolution),
+		}
+		if arrival.power > 0 {
+			angles[i].Power = 10 * math.Log10(arrival.power)
+		}
+	}
+	return angles
+}
+
+// receiverVoxels maps the voxels of receivers to the indices of the receivers
+// in them.
+func receiverVoxels(receivers []Point3D) map[[3]int][]int {
+	voxels := make(map[[3]int][]int)
+	for i, receiver := range receivers {
+		voxel := [3]int{int(math.Round(receiver.X)), int(math.Round(receiver.Y)), int(math.Round(receiver.Z))}
+		voxels[voxel] = append(voxels[voxel], i)
+	}
+	return voxels
+}
+
+// direction returns the azimuth and elevation in degrees of a direction
+// given in voxels.
+func (rl *RayLaunching3D) direction(dx, dy, dz float64) (float64, float64) {
+	cellX, cellY, levelHeight := rl.voxelSize()
+	dx, dy, dz = dx*cellX, dy*cellY, dz*levelHeight
+	azimuth := math.Atan2(dy, dx) * 180 / math.Pi
+	if azimuth < 0 {
+		azimuth += 360
+	}
+	return azimuth, math.Atan2(dz, math.Hypot(dx, dy)) * 180 / math.Pi
+}
+
+// addAngleSample records the arrival and launch direction of the ray's path
+// if voxel (xIdx, yIdx, zIdx) holds a receiver.
+func (rl *RayLaunching3D) addAngleSample(state *RayState, xIdx, yIdx, zIdx int) {
+	indices := rl.angles.receivers[[3]int{xIdx, yIdx, zIdx}]
+	if len(indices) == 0 {
+		return
+	}
+	sample := angleSample{power: math.Pow(10, state.currPower/10)}
+	sample.arrivalAzimuth, sample.arrivalElevation = rl.direction(-state.dx, -state.dy, -state.dz)
+	sample.departureAzimuth, sample.departureElevation = rl.direction(state.launchDir.X, state.launchDir.Y, state.launchDir.Z)
+	for _, i := range indices {
+		rl.angles.add(i, state.path, sample)
+	}
+}
@@ -0,0 +1,100 @@
+package raylaunching
+
+// createIndoorMap marks the voxels labelled as building interior before any
+// ray changes them to power values.
+func (rl *RayLaunching3D) createIndoorMap() [][][]bool {
+	indoor := make([][][]bool, len(rl.PowerMap))
+	for z := range rl.PowerMap {
+		indoor[z] = make([][]bool, len(rl.PowerMap[z]))
+		for y := range rl.PowerMap[z] {
+			indoor[z][y] = make([]bool, len(rl.PowerMap[z][y]))
+			for x, value := range rl.PowerMap[z][y] {
+				indoor[z][y][x] = int(value) == rl.Config.BuldingInteriorNumber
+			}
+		}
+	}
+	return indoor
+}
+
+// isEmptyVoxel reports whether a voxel of PowerMap holds no power yet and may
+// take any value. With wall penetration this includes building interiors.
+func (rl *RayLaunching3D) isEmptyVoxel(x, y, z int, value float64) bool {
+	if value == -160 {
+		return true
+	}
+	return rl.IndoorMap != nil && rl.IndoorMap[z][y][x] && int(value) == rl.Config.BuldingInteriorNumber
+}
+
+// penetrationLoss returns the loss of crossing the wall, corner or roof
+// voxel with label index at (xIdx, yIdx).
+func (rl *RayLaunching3D) penetrationLoss(index, xIdx, yIdx int) float64 {
+	var material Material
+	switch {
+	case index >= rl.Config.WallMapNumber && index < rl.Config.RoofMapNumber:
+		material = rl.Config.Materials.wallMaterial(index - rl.Config.WallMapNumber)
+	case index == rl.Config.RoofMapNumber:
+		material = rl.Config.Materials.roofMaterial(xIdx, yIdx)
+	default:
+		material = rl.Config.Materials.wallMaterial(-1)
+	}
+	return material.penetrationLossdB(rl.Config.TransmitterFreq)
+}
+
+// transmission returns the vertex of a ray crossing the building shell voxel
+// with label index at (xIdx, yIdx) with the given loss.
+func (rl *RayLaunching3D) transmission(index, xIdx, yIdx int, loss float64) RayVertex {
+	vertex := interaction(InteractionTransmission, -1, rl.Config.Materials.buildingIndex(xIdx, yIdx))
+	if index >= rl.Config.WallMapNumber && index < rl.Config.RoofMapNumber {
+		vertex.Wall = index - rl.Config.WallMapNumber
+		vertex.Building = rl.Config.Materials.wallBuilding(vertex.Wall)
+	}
+	vertex.PenetrationLoss = loss
+	return vertex
+}
+
+func (rl *RayLaunching3D) isBuildingShell(index int) bool {
+	return (index >= rl.Config.WallMapNumber && index < rl.Config.RoofMapNumber) ||
+		index == rl.Config.RoofMapNumber || index == rl.Config.CornerMapNumber || index == rl.Config.RoofCornerMapNumber
+}
+
+// penetrateWall follows the part of a ray that is transmitted through the wall
+// with label index, starting from the state right before the reflection. The
+// transmitted ray keeps its direction and loses the penetration loss of every
+// wall, corner or roof it crosses (consecutive voxels of the same element
+// count as one crossing). Inside buildings it is only reflected by the floor.
+func (rl *RayLaunching3D) penetrateWall(state RayState, index int) {
+	if !rl.Config.WallPenetration {
+		return
+	}
+	xIdx, yIdx, _ := rl.getMapIndices(state.x, state.y, state.z)
+	loss := rl.penetrationLoss(index, xIdx, yIdx)
+	state.penetrationLossdB += loss
+	state.currInteractions++
+	state.currWallIndex = index
+	vertex := rl.transmission(index, xIdx, yIdx, loss)
+	rl.extendPath(&state, vertex)
+	rl.startBranch(&state, vertex)
+
+	for rl.shouldContinueRay(&state) {
+		rl.handleGroundReflection(&state)
+		xIdx, yIdx, zIdx := rl.getMapIndices(state.x, state.y, state.z)
+		index := int(rl.PowerMap[zIdx][yIdx][xIdx])
+		if rl.isBuildingShell(index) {
+			if index != state.currWallIndex {
+				loss := rl.penetrationLoss(index, xIdx, yIdx)
+				state.penetrationLossdB += loss
+				state.currInteractions++
+				state.currWallIndex = index
+				rl.interact(&state, rl.transmission(index, xIdx, yIdx, loss))
+			}
+		} else {
+			state.currWallIndex = 0
+			rl.updatePowerMap(&state, xIdx, yIdx, zIdx)
+			rl.addToRayPath(state.targetRayIndex, &state)
+		}
+		state.x += state.dx
+		state.y += state.dy
+		state.z += state.dz
+	}
+	rl.addVertex(&state, interaction(InteractionTermination, -1, -1))
+}
@@ -0,0 +1,35 @@
+package raylaunching
+
+import (
+	. "backendGo/types"
+	"math"
+	"testing"
+)
+
+// TestPenetrateWall compares the power behind a glass wall with the power at
+// the same voxels without the wall: the rays crossing the wall keep their
+// direction and lose the penetration loss of glass once.
+func TestPenetrateWall(t *testing.T) {
+	run := func(wallX int) [][][]float64 {
+		matrix, normals := testMap(40, 40, 8, wallX)
+		config := testConfig(40, 40, 8, Point3D{X: 10, Y: 20, Z: 3})
+		config.WallPenetration = true
+		config.Materials = &MapMaterials{Walls: []WallInfo{{Material: "glass"}}}
+		rl := NewRayLaunching3D(matrix, normals, config)
+		rl.CalculateRayLaunching3D()
+		return rl.PowerMap
+	}
+	withWall, withoutWall := run(30), run(-1)
+
+	// 3GPP TR 38.901 glass: 2 dB + 0.2 dB/GHz
+	wantLoss := 2 + 0.2*2.4
+	for x := 31; x < 40; x++ {
+		behind, free := withWall[3][20][x], withoutWall[3][20][x]
+		if behind == -160 || free == -160 {
+			t.Fatalf("voxel (%d, 20, 3) not reached: %.2f dBm with the wall, %.2f dBm without", x, behind, free)
+		}
+		if loss := free - behind; math.Abs(loss-wantLoss) > 1e-9 {
+			t.Errorf("voxel (%d, 20, 3): loss %.4f dB behind the wall, want %.4f dB", x, loss, wantLoss)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package raylaunching
+
+import (
+	. "backendGo/types"
+	"math"
+)
+
+const (
+	defaultAntennaBeamwidth   = 65.0 // degrees
+	defaultAntennaAttenuation = 30.0 // dB
+	defaultAntennaMaxGain     = 8.0  // dBi
+)
+
+func orDefault(value, fallback float64) float64 {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
+// antennaGain returns the gain in dBi of antenna towards direction
+// (dx, dy, dz) of the map (x east, y south, z up). A nil antenna is isotropic.
+func antennaGain(antenna *Antenna, dx, dy, dz float64) float64 {
+	if antenna == nil {
+		return 0
+	}
+	length := math.Sqrt(dx*dx + dy*dy + dz*dz)
+	if length == 0 {
+		return 0
+	}
+	east, north, up := dx/length, -dy/length, dz/length
+
+	// rotate into the antenna frame: forward along the horizontal boresight and
+	// side to the right of it, then tilt forward and up by the mechanical tilt
+	azimuth := antenna.Azimuth * math.Pi / 180
+	tilt := antenna.MechanicalTilt * math.Pi / 180
+	forward := east*math.Sin(azimuth) + north*math.Cos(azimuth)
+	side := east*math.Cos(azimuth) - north*math.Sin(azimuth)
+	forward, up = forward*math.Cos(tilt)-up*math.Sin(tilt), forward*math.Sin(tilt)+up*math.Cos(tilt)
+
+	theta := 90 - math.Asin(math.Max(-1, math.Min(1, up)))*180/math.Pi // zenith angle
+	phi := math.Atan2(side, forward) * 180 / math.Pi
+
+	maxAttenuation := orDefault(antenna.FrontToBackRatio, defaultAntennaAttenuation)
+	verticalBeamwidth := orDefault(antenna.VerticalBeamwidth, defaultAntennaBeamwidth)
+	horizontalBeamwidth := orDefault(antenna.HorizontalBeamwidth, defaultAntennaBeamwidth)
+	sideLobeLevel := orDefault(antenna.SideLobeLevel, defaultAntennaAttenuation)
+
+	vertical := -math.Min(12*math.Pow((theta-90-antenna.ElectricalTilt)/verticalBeamwidth, 2), sideLobeLevel)
+	horizontal := -math.Min(12*math.Pow(phi/horizontalBeamwidth, 2), maxAttenuation)
+
+	maxGain := defaultAntennaMaxGain
+	if antenna.MaxGain != nil {
+		maxGain = *antenna.MaxGain
+	}
+	return maxGain - math.Min(-(vertical+horizontal), maxAttenuation)
+}
@@ -0,0 +1,42 @@
+package raylaunching
+
+import (
+	. "backendGo/types"
+	"math"
+	"testing"
+)
+
+// bearingDirection returns the map direction (x east, y south, z up) of a
+// compass bearing and an elevation in degrees.
+func bearingDirection(bearing, elevation float64) (float64, float64, float64) {
+	b, e := bearing*math.Pi/180, elevation*math.Pi/180
+	return math.Sin(b) * math.Cos(e), -math.Cos(b) * math.Cos(e), math.Sin(e)
+}
+
+func TestAntennaGain(t *testing.T) {
+	maxGain := 15.0
+	east := &Antenna{Azimuth: 90}
+	for _, test := range []struct {
+		name               string
+		antenna            *Antenna
+		bearing, elevation float64
+		want               float64 // dBi
+	}{
+		{"isotropic", nil, 123, 45, 0},
+		{"boresight", east, 90, 0, 8},
+		{"half the horizontal beamwidth", east, 90 - 32.5, 0, 5},
+		{"half the vertical beamwidth", east, 90, 32.5, 5},
+		{"back lobe", east, 270, 0, 8 - 30},
+		{"side lobe level", &Antenna{Azimuth: 90, VerticalBeamwidth: 10, SideLobeLevel: 20}, 90, -30, 8 - 20},
+		{"horizontal beamwidth", &Antenna{Azimuth: 0, HorizontalBeamwidth: 90}, 45, 0, 5},
+		{"front to back ratio", &Antenna{Azimuth: 0, FrontToBackRatio: 20}, 180, 0, 8 - 20},
+		{"mechanical tilt", &Antenna{Azimuth: 180, MechanicalTilt: 10}, 180, -10, 8},
+		{"electrical tilt", &Antenna{Azimuth: 180, ElectricalTilt: 6}, 180, -6, 8},
+		{"max gain", &Antenna{Azimuth: 90, MaxGain: &maxGain}, 90, 0, 15},
+	} {
+		dx, dy, dz := bearingDirection(test.bearing, test.elevation)
+		if got := antennaGain(test.antenna, dx, dy, dz); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: gain %.4f dBi, want %.4f dBi", test.name, got, test.want)
+		}
+	}
+}
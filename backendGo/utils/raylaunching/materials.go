@@ -0,0 +1,177 @@
+package raylaunching
+
+import (
+	. "backendGo/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+const (
+	DefaultWallMaterial   = "concrete"
+	DefaultRoofMaterial   = "concrete"
+	DefaultGroundMaterial = "medium-dry-ground"
+)
+
+type Material struct {
+	// Permittivity is the relative permittivity used in the Fresnel equations.
+	Permittivity float64 `json:"permittivity"`
+	// PenetrationLoss and PenetrationLossPerGHz give the loss of a single wall
+	// crossing in dB as PenetrationLoss + PenetrationLossPerGHz * f[GHz].
+	PenetrationLoss       float64 `json:"penetrationLoss"`
+	PenetrationLossPerGHz float64 `json:"penetrationLossPerGHz"`
+}
+
+func (m Material) penetrationLossdB(frequency float64) float64 {
+	return m.PenetrationLoss + m.PenetrationLossPerGHz*frequency/1e9
+}
+
+// builtInMaterials covers the values of building:material, building:facade:material
+// and roof:material most often found in OSM, plus the materials used before
+// per-building materials were introduced. Relative permittivities follow
+// ITU-R P.2040 where a value is given there. Penetration losses are the
+// 3GPP TR 38.901 (table 7.4.3-1) values for concrete, glass, IRR glass and
+// wood, assigned to the closest of these classes.
+var builtInMaterials = map[string]Material{
+	"concrete":            {Permittivity: 5.31, PenetrationLoss: 5, PenetrationLossPerGHz: 4},
+	"reinforced_concrete": {Permittivity: 5.31, PenetrationLoss: 5, PenetrationLossPerGHz: 4},
+	"cement_block":        {Permittivity: 5.31, PenetrationLoss: 5, PenetrationLossPerGHz: 4},
+	"ceiling-board":       {Permittivity: 1.50, PenetrationLoss: 4.85, PenetrationLossPerGHz: 0.12},
+	"plaster":             {Permittivity: 2.94, PenetrationLoss: 4.85, PenetrationLossPerGHz: 0.12},
+	"plasterboard":        {Permittivity: 2.94, PenetrationLoss: 4.85, PenetrationLossPerGHz: 0.12},
+	"brick":               {Permittivity: 3.75, PenetrationLoss: 5, PenetrationLossPerGHz: 4},
+	"roof_tiles":          {Permittivity: 3.75, PenetrationLoss: 5, PenetrationLossPerGHz: 4},
+	"stone":               {Permittivity: 6.50, PenetrationLoss: 5, PenetrationLossPerGHz: 4},
+	"sandstone":           {Permittivity: 6.50, PenetrationLoss: 5, PenetrationLossPerGHz: 4},
+	"limestone":           {Permittivity: 7.50, PenetrationLoss: 5, PenetrationLossPerGHz: 4},
+	"slate":               {Permittivity: 7.00, PenetrationLoss: 5, PenetrationLossPerGHz: 4},
+	"wood":                {Permittivity: 1.99, PenetrationLoss: 4.85, PenetrationLossPerGHz: 0.12},
+	"timber_framing":      {Permittivity: 1.99, PenetrationLoss: 4.85, PenetrationLossPerGHz: 0.12},
+	"glass":               {Permittivity: 6.27, PenetrationLoss: 2, PenetrationLossPerGHz: 0.2},
+	"mirror":              {Permittivity: 1e6, PenetrationLoss: 23, PenetrationLossPerGHz: 0.3},
+	"metal":               {Permittivity: 1e6, PenetrationLoss: 40},
+	"steel":               {Permittivity: 1e6, PenetrationLoss: 40},
+	"copper":              {Permittivity: 1e6, PenetrationLoss: 40},
+	"tin":                 {Permittivity: 1e6, PenetrationLoss: 40},
+	"asphalt":             {Permittivity: 3.18, PenetrationLoss: 5, PenetrationLossPerGHz: 4},
+	"tar_paper":           {Permittivity: 3.18, PenetrationLoss: 4.85, PenetrationLossPerGHz: 0.12},
+	"eternit":             {Permittivity: 5.31, PenetrationLoss: 5, PenetrationLossPerGHz: 4},
+	"gravel":              {Permittivity: 3.00, PenetrationLoss: 5, PenetrationLossPerGHz: 4},
+	"grass":               {Permittivity: 15, PenetrationLoss: 5, PenetrationLossPerGHz: 4},
+	"very-dry-ground":     {Permittivity: 3},
+	"medium-dry-ground":   {Permittivity: 15},
+	"wet-ground":          {Permittivity: 30},
+}
+
+// LoadMaterialLibrary returns the built-in materials extended (or overridden)
+// by the materials in the JSON file at path, e.g.
+//
+//	{"clinker": {"permittivity": 4.2, "penetrationLoss": 5, "penetrationLossPerGHz": 4}}
+//
+// A missing file is not an error.
+func LoadMaterialLibrary(path string) (map[string]Material, error) {
+	library := make(map[string]Material, len(builtInMaterials))
+	for name, material := range builtInMaterials {
+		library[name] = material
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return library, nil
+	}
+	if err != nil {
+		return library, err
+	}
+	var extra map[string]Material
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return library, fmt.Errorf("error parsing material library %s: %w", path, err)
+	}
+	for name, material := range extra {
+		library[strings.ToLower(name)] = material
+	}
+	return library, nil
+}
+
+// MapMaterials ties the walls and roofs of a map to materials of the library.
+type MapMaterials struct {
+	Library   map[string]Material
+	Walls     []WallInfo // indexed like WallNormals
+	Buildings []Building
+	// BuildingMap holds the index into Buildings for every (y, x) column, -1 for open air.
+	BuildingMap    [][]int16
+	GroundMaterial string
+}
+
+func (m *MapMaterials) lookup(name, fallback string) Material {
+	if m != nil && m.Library != nil {
+		if material, ok := m.Library[name]; ok {
+			return material
+		}
+		if material, ok := m.Library[fallback]; ok {
+			return material
+		}
+	}
+	if material, ok := builtInMaterials[name]; ok {
+		return material
+	}
+	return builtInMaterials[fallback]
+}
+
+func (m *MapMaterials) building(x, y int) *Building {
+	if m == nil || y < 0 || y >= len(m.BuildingMap) || x < 0 || x >= len(m.BuildingMap[y]) {
+		return nil
+	}
+	index := int(m.BuildingMap[y][x])
+	if index < 0 || index >= len(m.Buildings) {
+		return nil
+	}
+	return &m.Buildings[index]
+}
+
+// buildingIndex returns the index into Buildings of the building at (x, y),
+// -1 for open air.
+func (m *MapMaterials) buildingIndex(x, y int) int {
+	if m.building(x, y) == nil {
+		return -1
+	}
+	return int(m.BuildingMap[y][x])
+}
+
+// wallBuilding returns the index into Buildings of the building of the wall,
+// -1 if it is not known.
+func (m *MapMaterials) wallBuilding(wallIndex int) int {
+	if m == nil || wallIndex < 0 || wallIndex >= len(m.Walls) {
+		return -1
+	}
+	return m.Walls[wallIndex].Building
+}
+
+func (m *MapMaterials) buildingName(index int) string {
+	if m == nil || index < 0 || index >= len(m.Buildings) {
+		return ""
+	}
+	return m.Buildings[index].Name
+}
+
+func (m *MapMaterials) wallMaterial(wallIndex int) Material {
+	if m == nil || wallIndex < 0 || wallIndex >= len(m.Walls) {
+		return m.lookup(DefaultWallMaterial, DefaultWallMaterial)
+	}
+	return m.lookup(m.Walls[wallIndex].Material, DefaultWallMaterial)
+}
+
+func (m *MapMaterials) roofMaterial(x, y int) Material {
+	if building := m.building(x, y); building != nil {
+		return m.lookup(building.RoofMaterial, DefaultRoofMaterial)
+	}
+	return m.lookup(DefaultRoofMaterial, DefaultRoofMaterial)
+}
+
+func (m *MapMaterials) groundMaterial() Material {
+	if m == nil || m.GroundMaterial == "" {
+		return m.lookup(DefaultGroundMaterial, DefaultGroundMaterial)
+	}
+	return m.lookup(m.GroundMaterial, DefaultGroundMaterial)
+}
@@ -7,6 +7,7 @@ import styles from "./globalsettings.module.scss";
 type Props = {
 	formData: SettingsDataTypes;
 	handleFormSubmit: (event: React.FormEvent<HTMLFormElement>) => void;
+	maxStationHeight?: number;
 };
 const itemVariants = {
 	initial: { opacity: 0, x: 20 },
@@ -20,7 +21,7 @@ const itemVariants = {
 		},
 	}),
 };
-export default function GlobalSettings({ formData, handleFormSubmit }: Props) {
+export default function GlobalSettings({ formData, handleFormSubmit, maxStationHeight = 29 }: Props) {
 	return (
 		<form id="global-form" onSubmit={handleFormSubmit} className={styles.formBox}>
 			<div className={styles.formInputBox}>
@@ -110,7 +111,7 @@ export default function GlobalSettings({ formData, handleFormSubmit }: Props) {
 						name: "stationHeight",
 						value: formData.stationHeight,
 						min: 1,
-						max: 29,
+						max: maxStationHeight,
 						step: 1,
 						toolTipText:
 							"Specifies the station’s height above ground level in meters. It affects line-of-sight and coverage area.",
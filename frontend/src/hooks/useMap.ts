@@ -20,22 +20,20 @@ const fetchMapById = async (mapTitle: string) => {
 		throw new Error(`Failed to fetch maps`);
 	}
 };
-const fetchWallMatrix = async (mapTitle: string): Promise<Float64Array> => {
-	try {
-		const response = await axios.get(`${url}/maps/wallmatrix/${mapTitle}`, {
-			responseType: "arraybuffer",
-		});
-		return new Float64Array(response.data);
-	} catch (error) {
-		console.error(`Error fetching wall matrix`, error);
-		throw new Error("Failed to fetch wall matrix");
-	}
+
+type RayLaunchJob = {
+	id: string;
+	status: "queued" | "running" | "done" | "failed" | "cancelled";
+	progress: number;
+	error?: string;
 };
 
+const JOB_POLL_INTERVAL_MS = 1000;
+
 const startRayLaunching = async ({ mapTitle, configData }: { mapTitle: string; configData: any }) => {
 	console.log(configData);
 	try {
-		const response = await axios.post(
+		const response = await axios.post<RayLaunchJob>(
 			url + `/maps/rayLaunch/${mapTitle}`,
 			{ ...configData },
 			{
@@ -44,10 +42,19 @@ const startRayLaunching = async ({ mapTitle, configData }: { mapTitle: string; c
 				},
 			}
 		);
-		return response.data;
+		let job = response.data;
+		while (job.status === "queued" || job.status === "running") {
+			await new Promise(resolve => setTimeout(resolve, JOB_POLL_INTERVAL_MS));
+			job = (await axios.get<RayLaunchJob>(`${url}/maps/rayLaunch/jobs/${job.id}`)).data;
+		}
+		if (job.status !== "done") {
+			throw new Error(job.error ?? `Ray launching job ${job.status}`);
+		}
+		const result = await axios.get(`${url}/maps/rayLaunch/jobs/${job.id}/result`);
+		return result.data;
 	} catch (error) {
-		console.error(`Error fetching wall matrix`, error);
-		throw new Error("Failed to fetch wall matrix");
+		console.error(`Error running ray launching`, error);
+		throw new Error("Failed to run ray launching");
 	}
 };
 export const useGetMaps = () => {
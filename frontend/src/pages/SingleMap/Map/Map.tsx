@@ -1,7 +1,8 @@
-import { MapTypesExtended } from "@/types/main";
+import { MapGrid, MapTypesExtended } from "@/types/main";
 import { geoToMatrixIndex } from "@/utils/geoToMatrixIndex";
 import { getHeatMapColor } from "@/utils/getHeatMapColor";
 import { getMatrixValue } from "@/utils/getMatrixValue";
+import { matrixIndexToGeo } from "@/utils/matrixIndexToGeo";
 import { FeatureCollection, Position } from "geojson";
 import mapboxgl, { CustomLayerInterface, LngLatLike } from "mapbox-gl";
 import "mapbox-gl/dist/mapbox-gl.css";
@@ -28,12 +29,36 @@ const normalizePower = (value: number) => {
 	return (clamped - minPowerDb) / (maxPowerDb - minPowerDb);
 };
 
+// Corners of the power map image (south west, south east, north east, north
+// west). UTM grids are not aligned with the lat/lon bounds of the map.
+const powerMapCorners = (
+	coordinates: number[][][],
+	grid?: MapGrid
+): [[number, number], [number, number], [number, number], [number, number]] => {
+	if (grid?.projection !== "utm") {
+		return [
+			[coordinates[0][0][0], coordinates[0][0][1]],
+			[coordinates[0][1][0], coordinates[0][1][1]],
+			[coordinates[0][2][0], coordinates[0][2][1]],
+			[coordinates[0][3][0], coordinates[0][3][1]],
+		];
+	}
+	const corner = (i: number, j: number): [number, number] => {
+		const { lon, lat } = matrixIndexToGeo(i, j, 0, 0, 0, 0, 0, grid);
+		return [lon, lat];
+	};
+	const east = grid.sizeX - 0.5;
+	const south = grid.sizeY - 0.5;
+	return [corner(-0.5, south), corner(east, south), corner(east, -0.5), corner(-0.5, -0.5)];
+};
+
 export default function Map({
 	title,
 	coordinates,
 	center,
 	bounds,
 	size,
+	grid,
 	stationPos,
 	stationHeight,
 	handleStationPosUpdate,
@@ -188,12 +213,7 @@ export default function Map({
 					mapRef.current?.addSource(sourceId, {
 						type: "image",
 						url: imageUrl,
-						coordinates: [
-							[coordinates[0][0][0], coordinates[0][0][1]],
-							[coordinates[0][1][0], coordinates[0][1][1]],
-							[coordinates[0][2][0], coordinates[0][2][1]],
-							[coordinates[0][3][0], coordinates[0][3][1]],
-						],
+						coordinates: powerMapCorners(coordinates, grid),
 					});
 
 					mapRef.current?.addLayer(
@@ -339,9 +359,18 @@ export default function Map({
 				coordinates[0][2][0],
 				coordinates[0][0][1],
 				coordinates[0][2][1],
-				size
+				size,
+				grid
+			);
+			const value = getMatrixValue(
+				wallMatrix,
+				i,
+				j,
+				Math.round(Number(stationHeight) / (grid?.metresPerLevel ?? 1)),
+				grid?.sizeX ?? size,
+				grid?.sizeY ?? size,
+				grid?.sizeZ ?? 30
 			);
-			const value = getMatrixValue(wallMatrix, i, j, Number(stationHeight), size, size, 30);
 			canvas.style.cursor = "grabbing";
 
 			const pointGeometry = dragDropGeoJSON.features[0].geometry as GeoJSON.Point;
@@ -4,7 +4,7 @@ import Modal from "@/components/Modal/Modal";
 import SingleRaySettings from "@/components/Modal/SingleRaySettings/SingleRaySettings";
 import { useGetMapById, useRayLaunching } from "@/hooks/useMap";
 import Map from "@/pages/SingleMap/Map/Map";
-import { PowerMapLegendEntry, PowerMapLegendType, RayLaunchType, SettingsDataTypes } from "@/types/main";
+import { MapGrid, PowerMapLegendEntry, PowerMapLegendType, RayLaunchType, SettingsDataTypes } from "@/types/main";
 import { geoToMatrixIndex } from "@/utils/geoToMatrixIndex";
 import { getHeatMapColor } from "@/utils/getHeatMapColor";
 import { getMatrixValue } from "@/utils/getMatrixValue";
@@ -58,6 +58,10 @@ export default function SingleMap() {
 	const [powerMapLegend, setPowerMapLegend] = useState<PowerMapLegendType>(defaultPowerMapLegend);
 	const { id } = useParams();
 	const { data, isLoading, error } = useGetMapById(id!);
+	const grid: MapGrid | undefined = data?.mapData?.grid;
+	// the matrices and the power map are indexed by level, the settings are in metres
+	const metresPerLevel = grid?.metresPerLevel ?? 1;
+	const levels = grid?.sizeZ ?? 30;
 
 	const handleStationPosUpdate = (stationPos: mapboxgl.LngLatLike) => {
 		setSettingsData(prev => {
@@ -149,7 +153,10 @@ export default function SingleMap() {
 		const { stationPos, stationHeight, isOpen, ...restData } = settingsData;
 		mutate({
 			mapTitle: id!,
-			configData: { stationPos: { x: i, y: j, z: Number(stationHeight) }, size: data.mapData.size, ...restData },
+			configData: {
+				stationPos: { x: i, y: j, z: Math.round(Number(stationHeight) / metresPerLevel) },
+				...restData,
+			},
 		});
 	};
 
@@ -179,16 +186,17 @@ export default function SingleMap() {
 			data.mapData.coordinates[0][2][0],
 			data.mapData.coordinates[0][0][1],
 			data.mapData.coordinates[0][2][1],
-			data.mapData.size
+			data.mapData.size,
+			data.mapData.grid
 		);
 		const matrixIndexValue = getMatrixValue(
 			wallMatrix,
 			i,
 			j,
-			Number(settingsData.stationHeight),
-			data.mapData.size,
-			data.mapData.size,
-			30
+			Math.round(Number(settingsData.stationHeight) / metresPerLevel),
+			data.mapData.grid?.sizeX ?? data.mapData.size,
+			data.mapData.grid?.sizeY ?? data.mapData.size,
+			levels
 		);
 		return { matrixIndexValue, i, j };
 	}, [wallMatrix, settingsData?.stationHeight, settingsData?.stationPos, data?.mapData?.coordinates]);
@@ -209,9 +217,10 @@ export default function SingleMap() {
 						coordinates[0][2][0],
 						coordinates[0][0][1],
 						coordinates[0][2][1],
-						data.mapData.size
+						data.mapData.size,
+						data.mapData.grid
 					);
-					const coord = mapboxgl.MercatorCoordinate.fromLngLat([lon, lat], z ?? 0);
+					const coord = mapboxgl.MercatorCoordinate.fromLngLat([lon, lat], (z ?? 0) * metresPerLevel);
 					return {
 						coord,
 						power,
@@ -243,7 +252,11 @@ export default function SingleMap() {
 					<motion.div className={styles.dialogBox}>
 						<AnimatePresence mode="wait">
 							{settingsData.settingsType === "global" && (
-								<GlobalSettings handleFormSubmit={handleGlobalSettingsSubmit} formData={settingsData} />
+								<GlobalSettings
+									handleFormSubmit={handleGlobalSettingsSubmit}
+									formData={settingsData}
+									maxStationHeight={(levels - 1) * metresPerLevel}
+								/>
 							)}
 							{settingsData.settingsType === "singleRay" && (
 								<SingleRaySettings
@@ -335,7 +348,8 @@ export default function SingleMap() {
 												data.mapData.coordinates[0][2][0],
 												data.mapData.coordinates[0][0][1],
 												data.mapData.coordinates[0][2][1],
-												data.mapData.size
+												data.mapData.size,
+												data.mapData.grid
 											).i
 										}{" "}
 									</p>
@@ -350,7 +364,8 @@ export default function SingleMap() {
 												data.mapData.coordinates[0][2][0],
 												data.mapData.coordinates[0][0][1],
 												data.mapData.coordinates[0][2][1],
-												data.mapData.size
+												data.mapData.size,
+												data.mapData.grid
 											).j
 										}
 									</p>
@@ -370,7 +385,7 @@ export default function SingleMap() {
 								{settingsData.isPowerMapVisible ? "ON" : "OFF"}
 							</button>
 						</div>
-						<label>Height: {settingsData.powerMapHeight}m</label>
+						<label>Height: {settingsData.powerMapHeight * metresPerLevel}m</label>
 						<input
 							className={styles.slider}
 							type="range"
@@ -379,7 +394,7 @@ export default function SingleMap() {
 								setSettingsData(prev => ({ ...prev, powerMapHeight: Number(e.target.value) }))
 							}
 							min={0}
-							max={29}
+							max={levels - 1}
 						/>
 					</motion.div>
 					<button
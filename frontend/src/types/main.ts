@@ -41,6 +41,22 @@ export type SingleMapDataTypes = {
 	computationResult: mapboxgl.LngLatLike[][];
 };
 
+// Grid of the map matrices, see backendGo/utils/calculations/mapGrid.go
+export type MapGrid = {
+	projection: "utm" | "linear";
+	sizeX: number;
+	sizeY: number;
+	metresPerCellX: number;
+	metresPerCellY: number;
+	utmZone?: number;
+	south?: boolean;
+	originEasting?: number;
+	originNorthing?: number;
+	sizeZ: number;
+	metresPerLevel: number;
+	stride?: number;
+};
+
 export type MapTypes = {
 	title: string;
 	coordinates: number[][][];
@@ -58,6 +74,7 @@ export type MapTypesExtended = MapTypes & {
 	wallMatrix: Int16Array;
 	spherePositions: { positions: { coord: MercatorCoordinate; power: number }[]; rayIndex: number }[] | undefined;
 	size: number;
+	grid?: MapGrid;
 	powerMap: number[][][];
 	powerMapHeight: number;
 	isPowerMapVisible: boolean;
@@ -1,3 +1,6 @@
+import { MapGrid } from "@/types/main";
+import { toUTM } from "@/utils/utm";
+
 export function geoToMatrixIndex(
 	lon: number,
 	lat: number,
@@ -5,8 +8,16 @@ export function geoToMatrixIndex(
 	lonMax: number,
 	latMin: number,
 	latMax: number,
-	size: number
+	size: number,
+	grid?: MapGrid
 ): { i: number; j: number } {
+	if (grid?.projection === "utm") {
+		const { easting, northing } = toUTM(lat, lon, grid.utmZone!, grid.south);
+		const i = Math.round((easting - grid.originEasting!) / grid.metresPerCellX);
+		const j = grid.sizeY - 1 - Math.round((northing - grid.originNorthing!) / grid.metresPerCellY);
+		return { i, j };
+	}
+
 	const y = ((latMax - lat) / (latMax - latMin)) * (size - 1);
 	const x = ((lon - lonMin) / (lonMax - lonMin)) * (size - 1);
 
@@ -1,5 +1,46 @@
+import { url } from "@/utils/url";
+
+// Header of GET /maps/wallmatrix/:mapTitle, see backendGo/controllers/wallMatrix.go
+const WALL_MATRIX_MAGIC = "WMAT";
+const WALL_MATRIX_VERSION = 1;
+const WALL_MATRIX_DTYPE_INT16 = 1;
+const WALL_MATRIX_HEADER_SIZE = 32;
+
+export type WallMatrixHeader = {
+	sizeX: number;
+	sizeY: number;
+	sizeZ: number;
+	zStart: number;
+	zCount: number;
+};
+
+export const parseWallMatrix = (buffer: ArrayBuffer): { header: WallMatrixHeader; data: Int16Array } => {
+	const view = new DataView(buffer);
+	const magic = String.fromCharCode(...new Uint8Array(buffer, 0, 4));
+	if (magic !== WALL_MATRIX_MAGIC) {
+		throw new Error("Invalid wall matrix");
+	}
+	const version = view.getUint16(4, true);
+	const dtype = view.getUint16(6, true);
+	if (version !== WALL_MATRIX_VERSION || dtype !== WALL_MATRIX_DTYPE_INT16) {
+		throw new Error(`Unsupported wall matrix version ${version} / dtype ${dtype}`);
+	}
+	const header = {
+		sizeX: view.getUint32(8, true),
+		sizeY: view.getUint32(12, true),
+		sizeZ: view.getUint32(16, true),
+		zStart: view.getUint32(20, true),
+		zCount: view.getUint32(24, true),
+	};
+	const data = new Int16Array(buffer, WALL_MATRIX_HEADER_SIZE, header.zCount * header.sizeY * header.sizeX);
+	return { header, data };
+};
+
 export const loadWallMatrix = async (mapTitle: string): Promise<Int16Array> => {
-	const response = await fetch(`/maps/${mapTitle}/wallsMatrix3D_processed.bin`);
-	const buffer = await response.arrayBuffer();
-	return new Int16Array(buffer);
+	const response = await fetch(`${url}/maps/wallmatrix/${mapTitle}`);
+	if (!response.ok) {
+		throw new Error(`Failed to fetch wall matrix: ${response.status}`);
+	}
+	const { data } = parseWallMatrix(await response.arrayBuffer());
+	return data;
 };
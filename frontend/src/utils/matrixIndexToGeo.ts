@@ -1,3 +1,6 @@
+import { MapGrid } from "@/types/main";
+import { fromUTM } from "@/utils/utm";
+
 export function matrixIndexToGeo(
 	i: number,
 	j: number,
@@ -5,8 +8,15 @@ export function matrixIndexToGeo(
 	lonMax: number,
 	latMin: number,
 	latMax: number,
-	size: number
+	size: number,
+	grid?: MapGrid
 ): { lon: number; lat: number } {
+	if (grid?.projection === "utm") {
+		const easting = grid.originEasting! + i * grid.metresPerCellX;
+		const northing = grid.originNorthing! + (grid.sizeY - 1 - j) * grid.metresPerCellY;
+		return fromUTM(easting, northing, grid.utmZone!, grid.south);
+	}
+
 	const lon = lonMin + (i / (size - 1)) * (lonMax - lonMin);
 	const lat = latMax - (j / (size - 1)) * (latMax - latMin);
 
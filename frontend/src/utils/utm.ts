@@ -0,0 +1,73 @@
+// WGS84 / UTM conversions matching backendGo/utils/calculations/mapGrid.go
+const A = 6378137.0;
+const F = 1 / 298.257223563;
+const K0 = 0.9996;
+const E0 = 500000.0;
+const N_SOUTH = 10000000.0;
+const E2 = F * (2 - F);
+const EP2 = E2 / (1 - E2);
+
+const centralMeridian = (zone: number) => (((zone - 1) * 6 - 180 + 3) * Math.PI) / 180;
+
+export function toUTM(lat: number, lon: number, zone: number, south = false): { easting: number; northing: number } {
+	const phi = (lat * Math.PI) / 180;
+	const lambda = (lon * Math.PI) / 180;
+	const sinPhi = Math.sin(phi);
+	const cosPhi = Math.cos(phi);
+	const n = A / Math.sqrt(1 - E2 * sinPhi * sinPhi);
+	const t = Math.tan(phi) * Math.tan(phi);
+	const c = EP2 * cosPhi * cosPhi;
+	const a = (lambda - centralMeridian(zone)) * cosPhi;
+	const m =
+		A *
+		((1 - E2 / 4 - (3 * E2 * E2) / 64 - (5 * E2 * E2 * E2) / 256) * phi -
+			((3 * E2) / 8 + (3 * E2 * E2) / 32 + (45 * E2 * E2 * E2) / 1024) * Math.sin(2 * phi) +
+			((15 * E2 * E2) / 256 + (45 * E2 * E2 * E2) / 1024) * Math.sin(4 * phi) -
+			((35 * E2 * E2 * E2) / 3072) * Math.sin(6 * phi));
+
+	const easting =
+		K0 * n * (a + ((1 - t + c) * a ** 3) / 6 + ((5 - 18 * t + t * t + 72 * c - 58 * EP2) * a ** 5) / 120) + E0;
+	let northing =
+		K0 *
+		(m +
+			n *
+				Math.tan(phi) *
+				((a * a) / 2 +
+					((5 - t + 9 * c + 4 * c * c) * a ** 4) / 24 +
+					((61 - 58 * t + t * t + 600 * c - 330 * EP2) * a ** 6) / 720));
+	if (south) {
+		northing += N_SOUTH;
+	}
+	return { easting, northing };
+}
+
+export function fromUTM(easting: number, northing: number, zone: number, south = false): { lon: number; lat: number } {
+	const e1 = (1 - Math.sqrt(1 - E2)) / (1 + Math.sqrt(1 - E2));
+	const m = (south ? northing - N_SOUTH : northing) / K0;
+	const mu = m / (A * (1 - E2 / 4 - (3 * E2 * E2) / 64 - (5 * E2 * E2 * E2) / 256));
+	const phi1 =
+		mu +
+		((3 * e1) / 2 - (27 * e1 ** 3) / 32) * Math.sin(2 * mu) +
+		((21 * e1 * e1) / 16 - (55 * e1 ** 4) / 32) * Math.sin(4 * mu) +
+		((151 * e1 ** 3) / 96) * Math.sin(6 * mu) +
+		((1097 * e1 ** 4) / 512) * Math.sin(8 * mu);
+
+	const sinPhi1 = Math.sin(phi1);
+	const cosPhi1 = Math.cos(phi1);
+	const c1 = EP2 * cosPhi1 * cosPhi1;
+	const t1 = Math.tan(phi1) * Math.tan(phi1);
+	const n1 = A / Math.sqrt(1 - E2 * sinPhi1 * sinPhi1);
+	const r1 = (A * (1 - E2)) / Math.pow(1 - E2 * sinPhi1 * sinPhi1, 1.5);
+	const d = (easting - E0) / (n1 * K0);
+
+	const phi =
+		phi1 -
+		((n1 * Math.tan(phi1)) / r1) *
+			((d * d) / 2 -
+				((5 + 3 * t1 + 10 * c1 - 4 * c1 * c1 - 9 * EP2) * d ** 4) / 24 +
+				((61 + 90 * t1 + 298 * c1 + 45 * t1 * t1 - 252 * EP2 - 3 * c1 * c1) * d ** 6) / 720);
+	const lambda =
+		(d - ((1 + 2 * t1 + c1) * d ** 3) / 6 + ((5 - 2 * c1 + 28 * t1 - 3 * c1 * c1 + 8 * EP2 + 24 * t1 * t1) * d ** 5) / 120) /
+		cosPhi1;
+	return { lon: ((centralMeridian(zone) + lambda) * 180) / Math.PI, lat: (phi * 180) / Math.PI };
+}